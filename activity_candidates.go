@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+var (
+	activityCandidates *regexp.Regexp
+	activityChoose     *regexp.Regexp
+)
+
+type CandidateList struct {
+	ActivityId string          `json:"activity_id"`
+	Candidates []CandidateRule `json:"candidates"`
+}
+
+// CandidateChoice picks one of an activity's candidates, either by the rule's
+// weaviate id or by its rank (1 is the closest)
+type CandidateChoice struct {
+	WeaviateId string `json:"weaviate_id"`
+	Rank       int    `json:"rank"`
+}
+
+func init() {
+	activityCandidates = regexp.MustCompile(`^/api/v1/activity/([0-9a-f-]+)/candidates$`)
+	activityChoose = regexp.MustCompile(`^/api/v1/activity/([0-9a-f-]+)/choose$`)
+}
+
+func (h *ActivityManager) getCandidates(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("activity manager - candidates request received")
+
+	matches := activityCandidates.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
+		return
+	}
+	activityId := matches[1]
+
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		writeActivityStoreError(w, err)
+		return
+	}
+
+	// Rules may have changed since the activity was categorized, ?refresh=true asks again
+	if r.URL.Query().Get("refresh") == "true" {
+		candidates, err := categorizer.FindCandidates(r.Context(), activity.InputDescription)
+		if err != nil {
+			log.Printf("\terror finding candidate rules: %v", err)
+			http.Error(w, "Error finding candidate rules: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		activity.Candidates = candidates[:min(candidateCount, len(candidates))]
+		if err := activityStore.Update(activity); err != nil {
+			http.Error(w, "Error updating activity: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := CandidateList{
+		ActivityId: activity.ActivityId,
+		Candidates: activity.Candidates,
+	}
+	if response.Candidates == nil {
+		response.Candidates = []CandidateRule{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *ActivityManager) chooseCandidate(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("activity manager - candidate choice received")
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		log.Printf("\tinvalid content type: %s", contentType)
+		http.Error(w, "content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	matches := activityChoose.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
+		return
+	}
+	activityId := matches[1]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var choice CandidateChoice
+	err = json.Unmarshal(body, &choice)
+	if err != nil {
+		http.Error(w, "error parsing input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		writeActivityStoreError(w, err)
+		return
+	}
+
+	if activity.PostedToJiraTempo {
+		http.Error(w, "activity has already been posted to Jira/Tempo and can't be changed", http.StatusConflict)
+		return
+	}
+
+	if choice.WeaviateId == "" && choice.Rank < 1 {
+		http.Error(w, "choose a candidate by weaviate_id or rank", http.StatusBadRequest)
+		return
+	}
+
+	index := choice.Rank - 1
+	if choice.WeaviateId != "" {
+		index = slices.IndexFunc(activity.Candidates, func(c CandidateRule) bool {
+			return c.WeaviateId == choice.WeaviateId
+		})
+	}
+	if index < 0 || index >= len(activity.Candidates) {
+		http.Error(w, "no such candidate for this activity", http.StatusNotFound)
+		return
+	}
+
+	activity = applyCandidate(activity, activity.Candidates[index])
+
+	err = activityStore.Update(activity)
+	if err != nil {
+		http.Error(w, "Error updating activity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("\tactivity '%s' categorized by choice as Jira: %s", activityId, activity.Jira)
+
+	publishActivity(eventActivityCategorized, activity)
+
+	go learnRuleFromActivity(activity)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(activity)
+}
+
+// A person picked this rule so it sticks regardless of grade, and is
+// treated as a manual edit so recategorization won't undo it
+func applyCandidate(activity Activity, candidate CandidateRule) Activity {
+	activity.Project = candidate.Project
+	activity.Task = candidate.Task
+	activity.Jira = candidate.Jira
+	activity.WeaviateId = candidate.WeaviateId
+	activity.RuleDescription = candidate.Description
+	activity.CategorizationDistance = candidate.Distance
+	activity.CategorizationGrade = candidate.Grade
+	activity.Categorized = true
+	activity.ManuallyEdited = true
+	return activity
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChooseCandidate(t *testing.T) {
+	candidates := []CandidateRule{
+		{WeaviateId: "r1", Project: "FEDS", Task: "Release", Jira: "FEDS-148", Description: "release notes", Distance: 0.2, Grade: "B"},
+		{WeaviateId: "r2", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "notes", Distance: 0.3, Grade: "C"},
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		posted   bool
+		wantCode int
+		wantJira string
+	}{
+		{"by rank", `{"rank": 2}`, false, http.StatusOK, "IZG-7"},
+		{"by weaviate id", `{"weaviate_id": "r1"}`, false, http.StatusOK, "FEDS-148"},
+		{"unknown weaviate id", `{"weaviate_id": "r9"}`, false, http.StatusNotFound, ""},
+		{"rank past the candidates", `{"rank": 3}`, false, http.StatusNotFound, ""},
+		{"nothing chosen", `{}`, false, http.StatusBadRequest, ""},
+		{"posted", `{"rank": 1}`, true, http.StatusConflict, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newActivityTest(t)
+
+			activity := testActivity("a1", testDay(14, 9))
+			activity.Project, activity.Task, activity.Jira = "", "", ""
+			activity.Categorized, activity.CategorizationGrade = false, "C"
+			activity.Candidates = candidates
+			activity.PostedToJiraTempo = test.posted
+			if err := activityStore.Create(activity); err != nil {
+				t.Fatal(err)
+			}
+
+			if code := activityRequest(t, http.MethodPost, "/api/v1/activity/a1/choose", test.body, nil); code != test.wantCode {
+				t.Fatalf("choose returned %d, want %d", code, test.wantCode)
+			}
+
+			saved, err := activityStore.Get("a1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Jira != test.wantJira || saved.Categorized != (test.wantJira != "") || saved.ManuallyEdited != (test.wantJira != "") {
+				t.Errorf("saved activity has Jira %q, categorized %t, manually edited %t, want Jira %q", saved.Jira, saved.Categorized, saved.ManuallyEdited, test.wantJira)
+			}
+		})
+	}
+
+	newActivityTest(t)
+	if code := activityRequest(t, http.MethodPost, "/api/v1/activity/b1/choose", `{"rank": 1}`, nil); code != http.StatusNotFound {
+		t.Errorf("choosing for an unknown activity returned %d, want 404", code)
+	}
+}
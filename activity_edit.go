@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+var activityConfirm *regexp.Regexp
+
+// ActivityEdit holds the fields a person is allowed to change by hand. For
+// PATCH only the fields present in the body are applied, PUT replaces all
+// of them.
+type ActivityEdit struct {
+	Project          *string `json:"project"`
+	Task             *string `json:"task"`
+	Jira             *string `json:"jira"`
+	Duration         *string `json:"duration"`
+	InputDescription *string `json:"input_description"`
+}
+
+func init() {
+	activityConfirm = regexp.MustCompile(`^/api/v1/activity/([0-9a-f-]+)/confirm$`)
+}
+
+func (h *ActivityManager) editActivity(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("activity manager - activity edit (%s) received", r.Method)
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		log.Printf("\tinvalid content type: %s", contentType)
+		http.Error(w, "content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	matches := activityById.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
+		return
+	}
+	activityId := matches[1]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var edit ActivityEdit
+	err = json.Unmarshal(body, &edit)
+	if err != nil {
+		http.Error(w, "error parsing input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		writeActivityStoreError(w, err)
+		return
+	}
+
+	if activity.PostedToJiraTempo && activity.TempoWorklogId == 0 {
+		log.Printf("\tactivity '%s' posted without a worklog id, not editing", activityId)
+		http.Error(w, errNoTempoWorklogId.Error(), http.StatusConflict)
+		return
+	}
+
+	if r.Method == "PUT" {
+		edit = edit.replaceAll()
+	}
+
+	if edit.Duration != nil && *edit.Duration != "" {
+		if _, ok := parseDuration(*edit.Duration); !ok {
+			http.Error(w, "unable to read a duration from '"+*edit.Duration+"'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before := activity
+	activity = edit.apply(activity)
+
+	// Tempo is changed first, if that fails the activity stays as it was so the two still agree
+	if activity.PostedToJiraTempo && tempoWorklogChanged(before, activity) {
+		if activity.TimeSpent <= 0 && edit.Duration != nil {
+			http.Error(w, "an activity posted to Jira/Tempo needs a duration", http.StatusBadRequest)
+			return
+		}
+
+		worklog, err := newTempoWorklog(r.Context(), activity)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := tempoClient.UpdateWorklog(r.Context(), activity.TempoWorklogId, worklog); err != nil {
+			log.Printf("\terror updating Tempo worklog %d: %v", activity.TempoWorklogId, err)
+			http.Error(w, "Error updating Jira/Tempo worklog: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		log.Printf("\tTempo worklog %d updated", activity.TempoWorklogId)
+	}
+
+	err = activityStore.Update(activity)
+	if err != nil {
+		http.Error(w, "Error updating activity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("\tactivity '%s' manually edited", activityId)
+
+	publishActivity(eventActivityUpdated, activity)
+
+	// A hand picked project/task/jira is worth remembering
+	if edit.Project != nil || edit.Task != nil || edit.Jira != nil {
+		go learnRuleFromActivity(activity)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(activity)
+}
+
+// Confirm says the categorization on an activity is right, which keeps it
+// safe from recategorization and lets it be learned from
+func (h *ActivityManager) confirmActivity(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("activity manager - activity confirm received")
+
+	matches := activityConfirm.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
+		return
+	}
+	activityId := matches[1]
+
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		writeActivityStoreError(w, err)
+		return
+	}
+
+	if activity.Jira == "" {
+		http.Error(w, "activity has no Jira to confirm, edit or choose a candidate instead", http.StatusBadRequest)
+		return
+	}
+
+	activity.Categorized = true
+	activity.ManuallyEdited = true
+
+	err = activityStore.Update(activity)
+	if err != nil {
+		http.Error(w, "Error updating activity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("\tactivity '%s' confirmed as Jira: %s", activityId, activity.Jira)
+
+	publishActivity(eventActivityCategorized, activity)
+
+	go learnRuleFromActivity(activity)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(activity)
+}
+
+func (h *ActivityManager) deleteActivity(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("activity manager - activity delete received")
+
+	matches := activityById.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
+		return
+	}
+	activityId := matches[1]
+
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		writeActivityStoreError(w, err)
+		return
+	}
+
+	if activity.PostedToJiraTempo {
+		if activity.TempoWorklogId == 0 {
+			log.Printf("\tactivity '%s' posted without a worklog id, not deleting", activityId)
+			http.Error(w, errNoTempoWorklogId.Error(), http.StatusConflict)
+			return
+		}
+
+		// The worklog goes first, an activity deleted here but left in Tempo would be forgotten
+		if err := tempoClient.DeleteWorklog(r.Context(), activity.TempoWorklogId); err != nil {
+			log.Printf("\terror deleting Tempo worklog %d: %v", activity.TempoWorklogId, err)
+			http.Error(w, "Error deleting Jira/Tempo worklog: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		log.Printf("\tTempo worklog %d deleted", activity.TempoWorklogId)
+	}
+
+	err = activityStore.Delete(activityId)
+	if err != nil {
+		writeActivityStoreError(w, err)
+		return
+	}
+
+	log.Printf("\tactivity '%s' deleted", activityId)
+
+	publishActivity(eventActivityDeleted, activity)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// For PUT a field left out of the body is cleared rather than kept
+func (e ActivityEdit) replaceAll() ActivityEdit {
+	empty := ""
+	for _, field := range []**string{&e.Project, &e.Task, &e.Jira, &e.Duration, &e.InputDescription} {
+		if *field == nil {
+			*field = &empty
+		}
+	}
+	return e
+}
+
+func (e ActivityEdit) apply(activity Activity) Activity {
+	if e.Project != nil {
+		activity.Project = *e.Project
+	}
+	if e.Task != nil {
+		activity.Task = *e.Task
+	}
+	if e.Jira != nil {
+		activity.Jira = *e.Jira
+	}
+	if e.Duration != nil {
+		// Already checked that it parses, keep the display string in the usual format
+		activity.TimeSpent, _ = parseDuration(*e.Duration)
+		activity.Duration = ""
+		if activity.TimeSpent > 0 {
+			activity.Duration = formatDuration(activity.TimeSpent)
+		}
+	}
+	if e.InputDescription != nil {
+		activity.InputDescription = *e.InputDescription
+	}
+
+	// Someone choosing the Jira by hand is as categorized as it gets
+	if e.Project != nil || e.Task != nil || e.Jira != nil {
+		activity.Categorized = activity.Jira != ""
+	}
+
+	activity.ManuallyEdited = true
+
+	return activity
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultActivityPageSize = 50
+	maxActivityPageSize     = 500
+	// Far more pages than there will ever be, keeps (page-1)*page_size from overflowing
+	maxActivityPage = 1_000_000
+)
+
+type ActivityList struct {
+	Activities []Activity `json:"activities"`
+	Page       int        `json:"page"`
+	PageSize   int        `json:"page_size"`
+	Total      int        `json:"total"`
+}
+
+// Everything that can be filtered on from the query string, empty/nil means
+// the filter wasn't supplied
+type activityFilter struct {
+	project     string
+	jira        string
+	grades      []string
+	categorized *bool
+	posted      *bool
+}
+
+func (h *ActivityManager) listActivities(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("activity manager - list activities request received: %s", r.URL.RawQuery)
+
+	query := r.URL.Query()
+
+	from, to, err := parseDateRange(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseActivityFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, pageSize, err := parsePaging(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activities, err := activityStore.List(from, to)
+	if err != nil {
+		log.Printf("\terror listing activities: %v", err)
+		http.Error(w, "Error reading activities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var matched []Activity
+	for _, activity := range activities {
+		if filter.matches(activity) {
+			matched = append(matched, activity)
+		}
+	}
+
+	response := ActivityList{
+		Activities: []Activity{},
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      len(matched),
+	}
+
+	// Past the last page is an empty page, checked before multiplying out the start
+	if page-1 < (len(matched)+pageSize-1)/pageSize {
+		start := (page - 1) * pageSize
+		end := min(start+pageSize, len(matched))
+		response.Activities = matched[start:end]
+	}
+
+	log.Printf("\treturning %d of %d matching activities", len(response.Activities), response.Total)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// from/to are YYYYMMDD like the dated routes. Both default to today and
+// to defaults to from so ?from=20250513 is a single day.
+func parseDateRange(query url.Values) (time.Time, time.Time, error) {
+	from := startOfDay(time.Now())
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date '%s', expected YYYYMMDD", value)
+		}
+		from = parsed
+	}
+
+	to := from
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date '%s', expected YYYYMMDD", value)
+		}
+		to = parsed
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to date must not be before from date")
+	}
+
+	return from, to, nil
+}
+
+func parseActivityFilter(query url.Values) (activityFilter, error) {
+	filter := activityFilter{
+		project: query.Get("project"),
+		jira:    query.Get("jira"),
+	}
+
+	if value := query.Get("grade"); value != "" {
+		for _, grade := range strings.Split(value, ",") {
+			filter.grades = append(filter.grades, strings.ToUpper(strings.TrimSpace(grade)))
+		}
+	}
+
+	var err error
+	if filter.categorized, err = parseOptionalBool(query, "categorized"); err != nil {
+		return filter, err
+	}
+	if filter.posted, err = parseOptionalBool(query, "posted"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func parseOptionalBool(query url.Values, name string) (*bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s', expected true or false", name, value)
+	}
+
+	return &parsed, nil
+}
+
+// page is 1 based
+func parsePaging(query url.Values) (int, int, error) {
+	page := 1
+	if value := query.Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxActivityPage {
+			return 0, 0, fmt.Errorf("invalid page '%s', must be 1 to %d", value, maxActivityPage)
+		}
+		page = parsed
+	}
+
+	pageSize := defaultActivityPageSize
+	if value := query.Get("page_size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxActivityPageSize {
+			return 0, 0, fmt.Errorf("invalid page_size '%s', must be 1 to %d", value, maxActivityPageSize)
+		}
+		pageSize = parsed
+	}
+
+	return page, pageSize, nil
+}
+
+func (f activityFilter) matches(activity Activity) bool {
+	if f.project != "" && !strings.EqualFold(f.project, activity.Project) {
+		return false
+	}
+	if f.jira != "" && !strings.EqualFold(f.jira, activity.Jira) {
+		return false
+	}
+	if len(f.grades) > 0 && !slices.Contains(f.grades, activity.CategorizationGrade) {
+		return false
+	}
+	if f.categorized != nil && *f.categorized != activity.Categorized {
+		return false
+	}
+	if f.posted != nil && *f.posted != activity.PostedToJiraTempo {
+		return false
+	}
+	return true
+}
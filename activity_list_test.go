@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestListActivitiesPaging(t *testing.T) {
+	newActivityTest(t)
+
+	for i := range 5 {
+		if err := activityStore.Create(testActivity("a"+strconv.Itoa(i), testDay(14, 9+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantIds  int
+	}{
+		{"first page", "page_size=2", http.StatusOK, 2},
+		{"second page", "page=2&page_size=2", http.StatusOK, 2},
+		{"last page", "page=3&page_size=2", http.StatusOK, 1},
+		{"past the end", "page=4&page_size=2", http.StatusOK, 0},
+		{"largest page", "page=" + strconv.Itoa(maxActivityPage) + "&page_size=" + strconv.Itoa(maxActivityPageSize), http.StatusOK, 0},
+		{"page that would overflow", "page=9223372036854775807&page_size=500", http.StatusBadRequest, 0},
+		{"page zero", "page=0", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var list ActivityList
+			code := activityRequest(t, http.MethodGet, "/api/v1/activity?from=20250514&"+test.query, "", &list)
+			if code != test.wantCode {
+				t.Fatalf("list returned %d, want %d", code, test.wantCode)
+			}
+			if code == http.StatusOK && (len(list.Activities) != test.wantIds || list.Total != 5) {
+				t.Errorf("list returned %v of %d, want %d of 5", activityIds(list.Activities), list.Total, test.wantIds)
+			}
+		})
+	}
+}
@@ -1,18 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"reflect"
 	"regexp"
-	"strconv"
 	"time"
 )
 
@@ -23,17 +20,11 @@ var (
 	activityByDateId  *regexp.Regexp
 	recategorizeById  *regexp.Regexp
 	activityToTempo   *regexp.Regexp
+	activityIdToTempo *regexp.Regexp
 )
 
 type ActivityManager struct{}
 
-type JiraTempoPayload struct {
-	IssueKey         string `json:"issueKey"`
-	TimeSpentSeconds int    `json:"timeSpentSeconds"`
-	StartDate        string `json:"startDate"`
-	Description      string `json:"description"`
-}
-
 func init() {
 	activityTodayCsv = regexp.MustCompile(`^/api/v1/activity/csv/today$`)
 	activityCsvByDate = regexp.MustCompile(`^/api/v1/activity/csv/([0-9]{8})$`)
@@ -41,6 +32,7 @@ func init() {
 	activityByDateId = regexp.MustCompile(`^/api/v1/activity/([0-9]{8})/([0-9a-f-]+)$`)
 	recategorizeById = regexp.MustCompile(`^/api/v1/activity/recategorize/([0-9a-f-]+)$`)
 	activityToTempo = regexp.MustCompile(`^/api/v1/activity/tempo/([0-9]{8})/([0-9a-f-]+)$`)
+	activityIdToTempo = regexp.MustCompile(`^/api/v1/activity/tempo/([0-9a-f-]+)$`)
 }
 
 func (h *ActivityManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -49,27 +41,70 @@ func (h *ActivityManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("activity manager - %s %s", r.Method, r.RequestURI)
 
 	switch {
+	// A day/range of dates has to be checked before an id, a date looks like one
+	case
+		r.Method == "POST" && activityDayToTempo.MatchString(r.URL.Path):
+		h.activitiesToTempo(w, r)
+	case
+		r.Method == "POST" && activityRangeToTempo.MatchString(r.URL.Path):
+		h.activitiesToTempo(w, r)
+	case
+		r.Method == "POST" && activityToTempo.MatchString(r.URL.Path):
+		h.activityToTempoById(w, r)
 	case
-		r.Method == "POST" && activityToTempo.MatchString(r.URL.String()):
+		r.Method == "POST" && activityIdToTempo.MatchString(r.URL.Path):
 		h.activityToTempoById(w, r)
+	case
+		r.Method == "POST" && r.URL.Path == "/api/v1/activity/recategorize":
+		h.startRecategorize(w, r)
+	case
+		r.Method == "POST" && activityChoose.MatchString(r.URL.Path):
+		h.chooseCandidate(w, r)
+	case
+		r.Method == "POST" && activityConfirm.MatchString(r.URL.Path):
+		h.confirmActivity(w, r)
 	case
 		r.Method == "POST":
 		h.saveActivity(w, r)
 	case
-		r.Method == "GET" && activityTodayCsv.MatchString(r.URL.String()):
+		r.Method == "GET" && r.URL.Path == "/api/v1/activity/tempo/outbox":
+		h.listTempoOutbox(w)
+	case
+		r.Method == "GET" && activityTempoReconcile.MatchString(r.URL.Path):
+		h.reconcileTempo(w, r)
+	case
+		r.Method == "GET" && r.URL.Path == "/api/v1/activity/recategorize/jobs":
+		h.listRecategorizeJobs(w)
+	case
+		r.Method == "GET" && recategorizeJobById.MatchString(r.URL.Path):
+		h.getRecategorizeJob(w, r)
+	case
+		r.Method == "GET" && r.URL.Path == "/api/v1/activity":
+		h.listActivities(w, r)
+	case
+		r.Method == "GET" && activityTodayCsv.MatchString(r.URL.Path):
 		h.getTodayCsv(w)
 	case
-		r.Method == "GET" && activityCsvByDate.MatchString(r.URL.String()):
+		r.Method == "GET" && activityCsvByDate.MatchString(r.URL.Path):
 		h.getCsvByDate(w, r)
 	case
-		r.Method == "GET" && activityById.MatchString(r.URL.String()):
+		r.Method == "GET" && activityCandidates.MatchString(r.URL.Path):
+		h.getCandidates(w, r)
+	case
+		r.Method == "GET" && activityById.MatchString(r.URL.Path):
 		h.getActivityById(w, r)
 	case
-		r.Method == "GET" && activityByDateId.MatchString(r.URL.String()):
+		r.Method == "GET" && activityByDateId.MatchString(r.URL.Path):
 		h.getActivityByDateId(w, r)
 	case
-		r.Method == "PATCH" && recategorizeById.MatchString(r.URL.String()):
+		r.Method == "PATCH" && recategorizeById.MatchString(r.URL.Path):
 		h.recategorizeActivity(w, r)
+	case
+		(r.Method == "PUT" || r.Method == "PATCH") && activityById.MatchString(r.URL.Path):
+		h.editActivity(w, r)
+	case
+		r.Method == "DELETE" && activityById.MatchString(r.URL.Path):
+		h.deleteActivity(w, r)
 	default:
 		http.Error(w, "invalid request", http.StatusBadRequest)
 	}
@@ -110,29 +145,48 @@ func (h *ActivityManager) saveActivity(w http.ResponseWriter, r *http.Request) {
 	request.ActivityId = uuid.New().String()
 	request.Categorized = false
 	request.PostedToJiraTempo = false
+	request.TempoWorklogId = 0
+	request.TempoState = ""
+	request.ManuallyEdited = false
+	request.ProcessingErrors = nil
 
 	log.Printf("\tassigned id %s\n", request.ActivityId)
 
-	// Have Ollama determine Jira/Tempo formatted duration
-	// from the user's input
-	duration, err := getDuration(request)
+	// With ASYNC_PROCESSING it's saved as is and the workers fill in the rest
+	request.Status = activityStatusPending
+	if !asyncProcessing {
+		request = processActivity(request)
+		request.Status = activityStatusProcessed
+	}
+
+	err = activityStore.Create(request)
 	if err != nil {
-		http.Error(w, "Error obtaining duration from input: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Error saving activity: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("\tollma extracted duration: %s\n", duration)
-	request.Duration = duration
+	log.Println("\tactivity saved")
+
+	publishActivity(eventActivityCreated, request)
 
-	request = categorizeActivity(request)
-	log.Printf("\tweaviate categorized as Project: %s\n", request.Project)
-	log.Printf("\tweaviate categorized as Task: %s\n", request.Task)
-	log.Printf("\tweaviate categorized as Jira: %s\n", request.Jira)
-	log.Printf("\tweaviate categorization grade: %s\n", request.CategorizationGrade)
+	if request.Status == activityStatusPending {
+		if processingQueue.enqueue(request.ActivityId) {
+			log.Println("\tactivity queued for processing")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(request)
+			return
+		}
 
-	saveActivityCsv(request)
+		log.Println("\tprocessing queue is full, processing now")
+		request = processActivity(request)
+		request.Status = activityStatusProcessed
+		if err := activityStore.Update(request); err != nil {
+			http.Error(w, "Error updating activity: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	log.Println("\tCSV entry saved")
+	publishActivity(eventActivityCategorized, request)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(request)
@@ -144,7 +198,7 @@ func (h *ActivityManager) recategorizeActivity(w http.ResponseWriter, r *http.Re
 	log.Println("activity manager - activity to recategorize received")
 
 	// Extract activity ID from URL using the regex pattern
-	matches := recategorizeById.FindStringSubmatch(r.URL.String())
+	matches := recategorizeById.FindStringSubmatch(r.URL.Path)
 	if len(matches) < 1 {
 		log.Printf("\tinvalid id received in URL")
 		http.Error(w, "invalid activity ID in URL", http.StatusBadRequest)
@@ -154,48 +208,38 @@ func (h *ActivityManager) recategorizeActivity(w http.ResponseWriter, r *http.Re
 
 	log.Printf("activity manager - REcategorize ID: %s\n", activityId)
 
-	// Generate today's filename based on current date
-	currentDate := time.Now().Format("20060102") // Format for YYYYMMDD
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", currentDate)
-
-	activity, err := getActivityInFileById(activityId, filename)
+	activity, err := activityStore.Get(activityId)
 	if err != nil {
-		log.Printf("\tunable to get activity from file: %s", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("\tunable to get activity '%s': %s", activityId, err)
+		writeActivityStoreError(w, err)
 		return
 	}
 
-	if (Activity{} == activity) {
-		log.Printf("\tactivity id '%s' found in file", activityId)
-		http.Error(w, "activity not found", http.StatusNotFound)
+	// Don't throw away what a person set by hand unless they ask for it with ?force=true
+	if activity.ManuallyEdited && r.URL.Query().Get("force") != "true" {
+		log.Printf("\tactivity '%s' was manually edited, not recategorizing", activityId)
+		http.Error(w, "activity was manually edited, use ?force=true to recategorize anyway", http.StatusConflict)
 		return
 	}
-
-	// TODO - this function and the saveActivity could be refactored, shared logic
-	// Have Ollama determine Jira/Tempo formatted duration
-	// from the user's input
-	duration, err := getDuration(activity)
-	if err != nil {
-		http.Error(w, "Error obtaining duration from input: "+err.Error(), http.StatusBadRequest)
+	// The worklog in Tempo would no longer match, same as the bulk recategorize skips these
+	if activity.PostedToJiraTempo || activity.TempoState != "" {
+		log.Printf("\tactivity '%s' has been posted to Jira/Tempo, not recategorizing", activityId)
+		http.Error(w, "activity has already been posted to Jira/Tempo and can't be recategorized", http.StatusConflict)
 		return
 	}
+	activity.ManuallyEdited = false
 
-	log.Printf("\tollma extracted duration: %s\n", duration)
-	activity.Duration = duration
-
-	activity = categorizeActivity(activity)
-	log.Printf("\tweaviate REcategorized as Project: %s\n", activity.Project)
-	log.Printf("\tweaviate REcategorized as Task: %s\n", activity.Task)
-	log.Printf("\tweaviate REcategorized as Jira: %s\n", activity.Jira)
-	log.Printf("\tweaviate REcategorization grade: %s\n", activity.CategorizationGrade)
+	activity = processActivity(activity)
+	activity.Status = activityStatusProcessed
 
-	// Update the activity in the CSV file
-	err = updateActivityInCSV(activity, filename)
+	err = activityStore.Update(activity)
 	if err != nil {
-		http.Error(w, "Error updating activity in CSV: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error updating activity: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	publishActivity(eventActivityCategorized, activity)
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(activity)
 }
@@ -204,38 +248,20 @@ func (h *ActivityManager) getTodayCsv(w http.ResponseWriter) {
 
 	log.Println("activity manager - request for today's CSV received")
 
-	// Generate today's filename based on current date
-	currentDate := time.Now().Format("20060102") // Format for YYYYMMDD
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", currentDate)
-
-	log.Printf("\tlooking for file: %s\n", filename)
-	// Check if the file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		log.Println("\tfile not found, likely no data saved today")
-		http.Error(w, "No activity data for today", http.StatusNotFound)
+	activities, err := activityStore.List(time.Now(), time.Now())
+	if err != nil {
+		log.Printf("\terror listing today's activities: %v", err)
+		http.Error(w, "Error reading activities: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Open the file
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Println("\terror unable to open file")
-		http.Error(w, "Error opening CSV file: "+err.Error(), http.StatusInternalServerError)
+	if len(activities) == 0 {
+		log.Println("\tno activities found, likely no data saved today")
+		http.Error(w, "No activity data for today", http.StatusNotFound)
 		return
 	}
-	defer file.Close()
 
-	// Set response headers for CSV file download
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.WriteHeader(http.StatusOK)
-
-	// Copy the file contents to the response
-	_, err = io.Copy(w, file)
-	if err != nil {
-		log.Printf("\terror responding with data: %v", err)
-		log.Printf("Error sending CSV file: %v", err)
-	}
+	writeActivitiesCsv(w, activityCsvFilename(time.Now()), activities)
 
 	log.Println("\ttoday's CSV returned to caller")
 }
@@ -245,43 +271,74 @@ func (h *ActivityManager) getCsvByDate(w http.ResponseWriter, r *http.Request) {
 	log.Println("activity manager - request for dated CSV received")
 
 	// Extract date from URL using regex patter
-	matches := activityCsvByDate.FindStringSubmatch(r.URL.String())
+	matches := activityCsvByDate.FindStringSubmatch(r.URL.Path)
 	if len(matches) < 1 {
 		log.Printf("\tinvalid date received in URL")
 		http.Error(w, "Invalid date in URL", http.StatusBadRequest)
 		return
 	}
 	fileDate := matches[1]
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", fileDate)
 
 	log.Printf("\tdate for CSV request is '%s'\n", fileDate)
 
-	file, err := getCsvFile(filename)
+	date, err := time.Parse("20060102", fileDate)
+	if err != nil {
+		http.Error(w, "Invalid date in URL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activities, err := activityStore.List(date, date)
 	if err != nil {
-		log.Printf("\tunable to get CSV file: %s", err.Error())
-		http.Error(w, fmt.Sprintf("error opening CSV file '%s'  %s", filename, err.Error()), http.StatusInternalServerError)
+		log.Printf("\tunable to list activities: %s", err.Error())
+		http.Error(w, fmt.Sprintf("error reading activities for '%s'  %s", fileDate, err.Error()), http.StatusInternalServerError)
 		return
 	}
-	defer file.Close() // Ensure the file is closed after we're done with it
 
-	// Set response headers for CSV file download
+	if len(activities) == 0 {
+		http.Error(w, fmt.Sprintf("no activity data found for '%s'", fileDate), http.StatusNotFound)
+		return
+	}
+
+	writeActivitiesCsv(w, activityCsvFilename(date), activities)
+
+	log.Printf("\tdata for CSV for '%s' returned to caller", fileDate)
+
+}
+
+// Respond with activities as a CSV file download, same layout as the daily CSV files
+func writeActivitiesCsv(w http.ResponseWriter, filename string, activities []Activity) {
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 	w.WriteHeader(http.StatusOK)
 
-	// Copy the file contents to the response
-	_, err = io.Copy(w, file)
-	if err != nil {
-		http.Error(w, "Error sending CSV file: "+err.Error(), http.StatusInternalServerError)
+	csvWriter := csv.NewWriter(w)
+	defer csvWriter.Flush()
+
+	if err := csvWriter.Write(getHeaders(Activity{})); err != nil {
+		log.Printf("Error sending CSV file: %v", err)
+		return
 	}
 
-	log.Printf("\tdata for CSV for '%s' returned to caller", fileDate)
+	for _, activity := range activities {
+		if err := csvWriter.Write(getActivitySlice(activity)); err != nil {
+			log.Printf("Error sending CSV file: %v", err)
+			return
+		}
+	}
+}
 
+// Not found from the store is a 404, anything else is on our side
+func writeActivityStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errActivityNotFound) {
+		http.Error(w, "activity not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
 func (h *ActivityManager) getActivityByDateId(w http.ResponseWriter, r *http.Request) {
 	// Extract activity ID from URL using the regex pattern
-	matches := activityByDateId.FindStringSubmatch(r.URL.String())
+	matches := activityByDateId.FindStringSubmatch(r.URL.Path)
 	if len(matches) < 2 {
 		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
 		return
@@ -289,15 +346,13 @@ func (h *ActivityManager) getActivityByDateId(w http.ResponseWriter, r *http.Req
 	fileDate := matches[1]
 	activityId := matches[2]
 
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", fileDate)
-
-	activity, err := getActivityInFileById(activityId, filename)
+	activity, err := activityStore.Get(activityId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeActivityStoreError(w, err)
 		return
 	}
 
-	if (Activity{} == activity) {
+	if !activityOnDate(activity, fileDate) {
 		http.Error(w, "activity not found", http.StatusNotFound)
 		return
 	}
@@ -310,25 +365,16 @@ func (h *ActivityManager) getActivityByDateId(w http.ResponseWriter, r *http.Req
 
 func (h *ActivityManager) getActivityById(w http.ResponseWriter, r *http.Request) {
 	// Extract activity ID from URL using the regex pattern
-	matches := activityById.FindStringSubmatch(r.URL.String())
+	matches := activityById.FindStringSubmatch(r.URL.Path)
 	if len(matches) < 2 {
 		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
 		return
 	}
 	activityId := matches[1]
 
-	// Generate today's filename based on current date
-	currentDate := time.Now().Format("20060102") // Format for YYYYMMDD
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", currentDate)
-
-	activity, err := getActivityInFileById(activityId, filename)
+	activity, err := activityStore.Get(activityId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	if (Activity{} == activity) {
-		http.Error(w, "activity not found", http.StatusNotFound)
+		writeActivityStoreError(w, err)
 		return
 	}
 
@@ -338,224 +384,35 @@ func (h *ActivityManager) getActivityById(w http.ResponseWriter, r *http.Request
 	return
 }
 
-func getActivityInFileById(activityId string, filename string) (Activity, error) {
-
-	// TODO - I've changed this so that if a file for the date isn't found or an id isn't found
-	// in the file that was opened I'm just returning an empty Activity. Eventually should rethink
-	// this to actually tell the caller what's up.
-
-	// Check if the file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		log.Printf("No activity data for activity id: %s\n", activityId)
-		return Activity{}, nil
-	}
-
-	// Open the CSV file
-	file, err := os.Open(filename)
-	if err != nil {
-		return Activity{}, fmt.Errorf("error opening csv file: %v", err)
-	}
-	defer file.Close()
-
-	// Create a CSV reader
-	reader := csv.NewReader(file)
-
-	// Read header row
-	headers, err := reader.Read()
-	if err != nil {
-		return Activity{}, fmt.Errorf("error reading csv headers: %v", err)
-	}
-
-	// Create a map to store header indices for easy lookup
-	headerIndex := make(map[string]int)
-	for i, header := range headers {
-		headerIndex[header] = i
-	}
-
-	// Read all records and find the one with matching activity ID
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return Activity{}, fmt.Errorf("error reading csv record: %v", err)
-		}
-
-		// Check if this record's ActivityId matches the requested one
-		if record[headerIndex["ActivityId"]] == activityId {
-			// Create an Activity struct from the CSV record
-			activity := Activity{}
-			activityValue := reflect.ValueOf(&activity).Elem()
-			activityType := reflect.TypeOf(activity)
-
-			// Map CSV values to struct fields
-			for i := 0; i < activityType.NumField(); i++ {
-				fieldName := activityType.Field(i).Name
-				if idx, exists := headerIndex[fieldName]; exists && idx < len(record) {
-					field := activityValue.FieldByName(fieldName)
-					if field.CanSet() {
-						// Set value based on field type
-						switch field.Kind() {
-						case reflect.String:
-							field.SetString(record[idx])
-						case reflect.Float64:
-							val, _ := strconv.ParseFloat(record[idx], 64)
-							field.SetFloat(val)
-						case reflect.Bool:
-							val, _ := strconv.ParseBool(record[idx])
-							field.SetBool(val)
-						case reflect.Struct:
-							// Handle time.Time
-							if field.Type() == reflect.TypeOf(time.Time{}) {
-								// First try our simplified format (what we're writing to CSV now)
-								t, err := time.Parse("2006-01-02 15:04:05", record[idx])
-								if err != nil {
-									// Try RFC3339 format
-									t, err = time.Parse(time.RFC3339, record[idx])
-									if err != nil {
-										// Try the original verbose format for backward compatibility
-										t, err = time.Parse("2006-01-02 15:04:05.999999 -0700 MST m=+0.000000000", record[idx])
-										if err != nil {
-											log.Printf("Error parsing time from '%s': %v", record[idx], err)
-										}
-									}
-								}
-								field.Set(reflect.ValueOf(t))
-							}
-						}
-					}
-				}
-			}
-			return activity, nil
-		}
-	}
-
-	log.Printf("activity not found: %s\n", activityId)
-	return Activity{}, nil
-}
-
-// updateActivityInCSV replaces a specific activity in the CSV file
-func updateActivityInCSV(activity Activity, filename string) error {
-	// Check if the file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return fmt.Errorf("no activity data file '%s' found", filename)
-	}
-
-	// Read the entire CSV file into memory
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("error opening csv file: %v", err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("error reading csv file: %v", err)
-	}
-
-	// Get headers from the first row
-	headers := records[0]
-	headerIndex := make(map[string]int)
-	for i, header := range headers {
-		headerIndex[header] = i
-	}
-
-	// Find the activity ID index
-	activityIdIndex, exists := headerIndex["ActivityId"]
-	if !exists {
-		return fmt.Errorf("ActivityId column not found in CSV")
-	}
-
-	// Find the row with the matching activity ID
-	rowIndex := -1
-	for i, record := range records {
-		if i == 0 { // Skip the header row
-			continue
-		}
-		if record[activityIdIndex] == activity.ActivityId {
-			rowIndex = i
-			break
-		}
-	}
-
-	if rowIndex == -1 {
-		return fmt.Errorf("activity with ID %s not found in CSV", activity.ActivityId)
-	}
-
-	// Replace the row with the updated activity values
-	records[rowIndex] = getActivitySlice(activity)
-
-	// Write the updated records back to the file
-	file.Close() // Close before writing to avoid issues
-	outFile, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("error creating file for writing: %v", err)
-	}
-	defer outFile.Close()
-
-	writer := csv.NewWriter(outFile)
-	defer writer.Flush()
-
-	err = writer.WriteAll(records)
-	if err != nil {
-		return fmt.Errorf("error writing updated records to CSV: %v", err)
-	}
-
-	log.Printf("Successfully updated activity %s in CSV file", activity.ActivityId)
-	return nil
-}
-
-// TODO - a function to trigger categorization of any today where Categorized = false
-
-func getCsvFile(fileName string) (*os.File, error) {
-	// Check if the file exists
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		return nil, fmt.Errorf("no activity data file '%s' found", fileName)
-	}
-
-	// Open the file
-	file, err := os.Open(fileName)
-	if err != nil {
-		return nil, fmt.Errorf("error opening csv file: %v", err)
-	}
-
-	return file, nil
+// The dated routes were around before activities could be found by id alone,
+// keep them meaning "this id on this day"
+func activityOnDate(activity Activity, date string) bool {
+	return activity.WorkDate().Format("20060102") == date
 }
 
 func (h *ActivityManager) activityToTempoById(w http.ResponseWriter, r *http.Request) {
-	// Look up id in today's file, create payload to send to Jira endpoint
-	// NOTE that as of now, the endpoint is completely faked out
-	// Example paylaod:
-	/*
-		{
-		    "issueKey": "FEDS-148",
-		    "timeSpentSeconds": 3600,
-		    "startDate": "2025-05-13",
-		    "description": "Working on task"
-		  }
-	*/
-	// So need to convert the stored duration to seconds, and the start date to just YYYY-MM-DD
-
-	// Extract activity ID from URL using the regex pattern
-	matches := activityToTempo.FindStringSubmatch(r.URL.String())
-	if len(matches) < 2 {
+	// Look up id (optionally checking the date) and push a Tempo worklog for it through
+	// the outbox, see tempo_worklog.go for how an activity maps to a worklog
+
+	// Extract activity ID (and date if using the dated route) from URL using the regex patterns
+	var activityId, fileDate string
+	if matches := activityToTempo.FindStringSubmatch(r.URL.Path); len(matches) == 3 {
+		fileDate = matches[1]
+		activityId = matches[2]
+	} else if matches := activityIdToTempo.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		activityId = matches[1]
+	} else {
 		http.Error(w, "Invalid activity ID in URL", http.StatusBadRequest)
 		return
 	}
-	activityId := matches[2]
-	fileDate := matches[1]
-
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", fileDate)
 
-	activity, err := getActivityInFileById(activityId, filename)
+	activity, err := activityStore.Get(activityId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeActivityStoreError(w, err)
 		return
 	}
 
-	if (Activity{} == activity) {
+	if fileDate != "" && !activityOnDate(activity, fileDate) {
 		http.Error(w, "activity not found", http.StatusNotFound)
 		return
 	}
@@ -570,65 +427,26 @@ func (h *ActivityManager) activityToTempoById(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	durationInSeconds, err := getDurationInSeconds(activity)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	jiraTempoPayload := JiraTempoPayload{
-		IssueKey:         activity.Jira,
-		TimeSpentSeconds: durationInSeconds,
-		Description:      activity.InputDescription,
-		StartDate:        activity.CreatedAt.Format("20060102"),
-	}
-
-	// Post to Jira endpoint
-	requestData, err := json.Marshal(jiraTempoPayload)
+	// Queued first so a failure here is retried in the background rather than lost
+	entry, err := tempoQueue.enqueue(activity)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error marshalling request: %w", err), http.StatusBadRequest)
+		http.Error(w, "Error queueing activity for Jira/Tempo: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	req, err := http.NewRequest("POST", jiraTempoEndpoint, bytes.NewBuffer(requestData))
+	entry, err = tempoQueue.attempt(r.Context(), entry.Key)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error creating request: %w", err), http.StatusBadRequest)
-		return
+		log.Printf("\terror pushing activity '%s' to Tempo: %v", activityId, err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error sending request to Jira/Tempo: %w", err), http.StatusBadRequest)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		responseBody, _ := io.ReadAll(resp.Body)
-		http.Error(w, fmt.Sprintf("Jira/Tempo API returned error: %s - %s", resp.Status, string(responseBody)), http.StatusBadRequest)
-		return
-	}
-
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error reading response body: %w", err), http.StatusBadRequest)
-		return
-	}
-
-	// Update the activity to mark it as posted to Jira/Tempo
-	activity.PostedToJiraTempo = true
-	err = updateActivityInCSV(activity, filename)
-	if err != nil {
-		// Even if we fail to update the file, we still successfully posted to Jira/Tempo
-		// So we'll log the error but still return success to the client
-		log.Printf("Error updating activity in file: %v", err)
+	switch entry.State {
+	case tempoStateSent:
+		w.WriteHeader(http.StatusOK)
+	case tempoStateFailed:
+		w.WriteHeader(http.StatusBadGateway)
+	default:
+		// Still pending, the outbox worker will keep trying
+		w.WriteHeader(http.StatusAccepted)
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
-	return
-
+	json.NewEncoder(w).Encode(entry)
 }
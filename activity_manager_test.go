@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newActivityTest points the activity globals at an empty SQLite store and a
+// categorizer that always answers with the candidates
+func newActivityTest(t *testing.T, candidates ...CandidateRule) {
+	t.Helper()
+
+	store, err := newSqliteActivityStore(filepath.Join(t.TempDir(), "activities.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousStore, previousCategorizer, previousGrades := activityStore, categorizer, autoGrades
+	t.Cleanup(func() {
+		activityStore, categorizer, autoGrades = previousStore, previousCategorizer, previousGrades
+	})
+	activityStore = store
+	categorizer = categorizerChain{stubCategorizer(candidates)}
+	autoGrades = []string{"A"}
+}
+
+func activityRequest(t *testing.T, method string, path string, body string, result interface{}) int {
+	t.Helper()
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	recorder := httptest.NewRecorder()
+	(&ActivityManager{}).ServeHTTP(recorder, request)
+
+	if result != nil && recorder.Code < 300 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			t.Fatalf("%s %s: error parsing %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestRecategorizeActivity(t *testing.T) {
+	candidate := CandidateRule{WeaviateId: "r2", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "notes", Distance: 0.04, Grade: "A"}
+
+	tests := []struct {
+		name     string
+		edit     func(*Activity)
+		query    string
+		wantCode int
+		wantJira string
+	}{
+		{"recategorized", func(a *Activity) {}, "", http.StatusCreated, "IZG-7"},
+		{"manually edited", func(a *Activity) { a.ManuallyEdited = true }, "", http.StatusConflict, "FEDS-148"},
+		{"manually edited forced", func(a *Activity) { a.ManuallyEdited = true }, "?force=true", http.StatusCreated, "IZG-7"},
+		{"posted", func(a *Activity) { a.PostedToJiraTempo = true }, "", http.StatusConflict, "FEDS-148"},
+		{"posted forced", func(a *Activity) { a.PostedToJiraTempo = true }, "?force=true", http.StatusConflict, "FEDS-148"},
+		{"waiting in the outbox", func(a *Activity) { a.TempoState = tempoStatePending }, "", http.StatusConflict, "FEDS-148"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newActivityTest(t, candidate)
+
+			activity := testActivity("a1", testDay(14, 9))
+			test.edit(&activity)
+			if err := activityStore.Create(activity); err != nil {
+				t.Fatal(err)
+			}
+
+			if code := activityRequest(t, http.MethodPatch, "/api/v1/activity/recategorize/a1"+test.query, "", nil); code != test.wantCode {
+				t.Errorf("recategorize returned %d, want %d", code, test.wantCode)
+			}
+
+			saved, err := activityStore.Get("a1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Jira != test.wantJira {
+				t.Errorf("saved Jira = %q, want %q", saved.Jira, test.wantJira)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Whether an activity's duration and categorization have been worked out yet
+const (
+	activityStatusPending   = "pending"
+	activityStatusProcessed = "processed"
+)
+
+// How far back to look for activities left pending by a restart. They haven't
+// been processed so they're still filed under the day they were created.
+const pendingLookback = 7 * 24 * time.Hour
+
+// processActivity works out an activity's duration, when the work happened and
+// its categorization, for new activities and recategorizing. Every stage falls
+// back rather than failing so the activity can always be saved, whatever went
+// wrong is kept in ProcessingErrors.
+func processActivity(activity Activity) Activity {
+	activity.ProcessingErrors = nil
+
+	// Determine Jira/Tempo formatted duration from the user's input
+	duration, source, promptVersion, err := getDuration(activity)
+	if err != nil {
+		log.Printf("\terror obtaining duration, using the default: %v", err)
+		activity.ProcessingErrors = append(activity.ProcessingErrors, "duration: "+err.Error())
+		duration, source = defaultDuration, durationSourceFallback
+	}
+
+	activity.TimeSpent = duration
+	activity.Duration = formatDuration(duration)
+	activity.DurationSource = source
+	activity.DurationPromptVersion = promptVersion
+	log.Printf("\textracted duration: %s (%s)\n", activity.Duration, source)
+
+	// Backdated or explicit times, "yesterday 2-4pm"
+	activity.StartedAt, activity.EndedAt, _ = parseWorkTime(activity.InputDescription, duration, activity.CreatedAt)
+	if !activity.StartedAt.IsZero() {
+		log.Printf("\textracted work time: %s to %s\n", activity.StartedAt.Format(time.DateTime), activity.EndedAt.Format(time.DateTime))
+	}
+
+	activity = categorizeActivity(activity)
+	log.Printf("\t%s categorized as Project: %s\n", activity.CategorizedBy, activity.Project)
+	log.Printf("\t%s categorized as Task: %s\n", activity.CategorizedBy, activity.Task)
+	log.Printf("\t%s categorized as Jira: %s\n", activity.CategorizedBy, activity.Jira)
+	log.Printf("\t%s categorization grade: %s\n", activity.CategorizedBy, activity.CategorizationGrade)
+
+	return activity
+}
+
+// activityProcessor runs processActivity in the background for ASYNC_PROCESSING,
+// so saving an activity doesn't wait on the LLM and Weaviate. The queue is
+// bounded, when it's full the request does the work itself instead.
+type activityProcessor struct {
+	queue chan string
+}
+
+var processingQueue *activityProcessor
+
+func newActivityProcessor(workers int, queueSize int) *activityProcessor {
+	p := &activityProcessor{queue: make(chan string, queueSize)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// enqueue returns false if the queue is full
+func (p *activityProcessor) enqueue(activityId string) bool {
+	select {
+	case p.queue <- activityId:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *activityProcessor) work() {
+	for activityId := range p.queue {
+		p.process(activityId)
+	}
+}
+
+func (p *activityProcessor) process(activityId string) {
+	log.Printf("activity processor - processing '%s'", activityId)
+
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		log.Printf("\terror getting activity '%s': %v", activityId, err)
+		return
+	}
+	if activity.Status != activityStatusPending {
+		return
+	}
+
+	processed := processActivity(activity)
+	processed.Status = activityStatusProcessed
+
+	// Read again in case it was edited or deleted while this was running
+	current, err := activityStore.Get(activityId)
+	if errors.Is(err, errActivityNotFound) {
+		log.Printf("\tactivity '%s' was deleted while processing", activityId)
+		return
+	}
+	if err != nil {
+		log.Printf("\terror getting activity '%s': %v", activityId, err)
+		return
+	}
+	if current.ManuallyEdited {
+		// What a person set wins over what was worked out
+		log.Printf("\tactivity '%s' was edited while processing, keeping the edit", activityId)
+		processed = current
+		processed.Status = activityStatusProcessed
+	}
+
+	if err := activityStore.Update(processed); err != nil {
+		log.Printf("\terror updating activity '%s': %v", activityId, err)
+		return
+	}
+
+	log.Printf("\tactivity '%s' processed", activityId)
+
+	publishActivity(eventActivityCategorized, processed)
+}
+
+// requeuePending picks back up activities that were still pending when the
+// tracker last stopped
+func (p *activityProcessor) requeuePending() {
+	activities, err := activityStore.List(time.Now().Add(-pendingLookback), time.Now())
+	if err != nil {
+		log.Printf("activity processor - error listing pending activities: %v", err)
+		return
+	}
+
+	requeued := 0
+	for _, activity := range activities {
+		if activity.Status != activityStatusPending {
+			continue
+		}
+		// Blocking is fine here, it's its own goroutine
+		p.queue <- activity.ActivityId
+		requeued++
+	}
+
+	log.Printf("activity processor - requeued %d pending activities", requeued)
+}
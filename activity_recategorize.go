@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var recategorizeJobById *regexp.Regexp
+
+func init() {
+	recategorizeJobById = regexp.MustCompile(`^/api/v1/activity/recategorize/jobs/([0-9a-f-]+)$`)
+}
+
+const (
+	recategorizeRunning  = "running"
+	recategorizeFinished = "finished"
+	recategorizeFailed   = "failed"
+)
+
+// Finished jobs are only kept in memory, the oldest go once there are more than this
+const maxRecategorizeJobs = 20
+
+// RecategorizeJob is a bulk recategorization running in the background,
+// fetched by id to follow its progress
+type RecategorizeJob struct {
+	JobId         string               `json:"job_id"`
+	State         string               `json:"state"`
+	From          string               `json:"from"`
+	To            string               `json:"to"`
+	Grades        []string             `json:"grades,omitempty"`
+	Uncategorized bool                 `json:"uncategorized"`
+	Total         int                  `json:"total"`
+	Processed     int                  `json:"processed"`
+	Changed       int                  `json:"changed"`
+	Unchanged     int                  `json:"unchanged"`
+	Skipped       int                  `json:"skipped"`
+	Errors        []string             `json:"errors,omitempty"`
+	Changes       []RecategorizeChange `json:"changes"`
+	StartedAt     time.Time            `json:"started_at"`
+	FinishedAt    time.Time            `json:"finished_at"`
+}
+
+// RecategorizeChange is an activity whose categorization came out different
+type RecategorizeChange struct {
+	ActivityId       string               `json:"activity_id"`
+	InputDescription string               `json:"input_description"`
+	Before           RecategorizeSnapshot `json:"before"`
+	After            RecategorizeSnapshot `json:"after"`
+}
+
+type RecategorizeSnapshot struct {
+	Project     string  `json:"project"`
+	Task        string  `json:"task"`
+	Jira        string  `json:"jira"`
+	Grade       string  `json:"grade"`
+	Distance    float64 `json:"distance"`
+	Categorized bool    `json:"categorized"`
+}
+
+func newRecategorizeSnapshot(activity Activity) RecategorizeSnapshot {
+	return RecategorizeSnapshot{
+		Project:     activity.Project,
+		Task:        activity.Task,
+		Jira:        activity.Jira,
+		Grade:       activity.CategorizationGrade,
+		Distance:    activity.CategorizationDistance,
+		Categorized: activity.Categorized,
+	}
+}
+
+type recategorizeJobs struct {
+	mu   sync.Mutex
+	jobs []*RecategorizeJob
+}
+
+var recategorizations = &recategorizeJobs{}
+
+func (j *recategorizeJobs) add(job *RecategorizeJob) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.jobs = append(j.jobs, job)
+
+	// Drop the oldest finished jobs, never a running one
+	for len(j.jobs) > maxRecategorizeJobs {
+		i := slices.IndexFunc(j.jobs, func(job *RecategorizeJob) bool { return job.State != recategorizeRunning })
+		if i < 0 {
+			break
+		}
+		j.jobs = slices.Delete(j.jobs, i, i+1)
+	}
+}
+
+// get returns a copy so it can be encoded while the job carries on
+func (j *recategorizeJobs) get(jobId string) (RecategorizeJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, job := range j.jobs {
+		if job.JobId == jobId {
+			return job.copy(), true
+		}
+	}
+	return RecategorizeJob{}, false
+}
+
+func (j *recategorizeJobs) list() []RecategorizeJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jobs := make([]RecategorizeJob, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job.copy())
+	}
+	return jobs
+}
+
+// update changes a job while holding the lock
+func (j *recategorizeJobs) update(job *RecategorizeJob, change func(job *RecategorizeJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	change(job)
+}
+
+func (job *RecategorizeJob) copy() RecategorizeJob {
+	copied := *job
+	copied.Grades = slices.Clone(job.Grades)
+	copied.Errors = slices.Clone(job.Errors)
+	copied.Changes = slices.Clone(job.Changes)
+	return copied
+}
+
+// wanted is whether the job's grades/uncategorized selection covers an activity
+func (job *RecategorizeJob) wanted(activity Activity) bool {
+	if job.Uncategorized && !activity.Categorized {
+		return true
+	}
+	return slices.Contains(job.Grades, activity.CategorizationGrade)
+}
+
+// Manually edited activities are what a person decided, posted ones are
+// already in Tempo, neither is ever recategorized in bulk
+func recategorizeSkipReason(activity Activity) string {
+	switch {
+	case activity.ManuallyEdited:
+		return "manually edited"
+	case activity.PostedToJiraTempo || activity.TempoState != "":
+		return "posted to Tempo"
+	case activity.Status == activityStatusPending:
+		return "still being processed"
+	}
+	return ""
+}
+
+// startRecategorize re-runs categorization over the activities between date
+// and to that are uncategorized and/or have one of the grades. It returns
+// straight away with a job to follow, GET .../recategorize/jobs/{id}.
+//
+// POST /api/v1/activity/recategorize?date=YYYYMMDD&to=YYYYMMDD&grades=C,D,F&uncategorized=true
+// Without grades or uncategorized only uncategorized activities are picked.
+func (h *ActivityManager) startRecategorize(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("activity manager - bulk recategorize request received: %s", r.URL.RawQuery)
+
+	query := r.URL.Query()
+
+	from := startOfDay(time.Now())
+	if value := query.Get("date"); value != "" {
+		parsed, err := time.Parse("20060102", value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid date '%s', expected YYYYMMDD", value), http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	to := from
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse("20060102", value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to date '%s', expected YYYYMMDD", value), http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+
+	if to.Before(from) {
+		http.Error(w, "to date must not be before date", http.StatusBadRequest)
+		return
+	}
+
+	job := &RecategorizeJob{
+		JobId:     uuid.New().String(),
+		State:     recategorizeRunning,
+		From:      from.Format("20060102"),
+		To:        to.Format("20060102"),
+		Changes:   []RecategorizeChange{},
+		StartedAt: time.Now(),
+	}
+
+	if value := query.Get("grades"); value != "" {
+		for _, grade := range strings.Split(value, ",") {
+			job.Grades = append(job.Grades, strings.ToUpper(strings.TrimSpace(grade)))
+		}
+	}
+
+	uncategorized, err := parseOptionalBool(query, "uncategorized")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job.Uncategorized = uncategorized != nil && *uncategorized
+	if uncategorized == nil && len(job.Grades) == 0 {
+		job.Uncategorized = true
+	}
+	if !job.Uncategorized && len(job.Grades) == 0 {
+		http.Error(w, "nothing to recategorize, give grades or uncategorized=true", http.StatusBadRequest)
+		return
+	}
+
+	activities, err := activityStore.List(from, to)
+	if err != nil {
+		log.Printf("\terror listing activities: %v", err)
+		http.Error(w, "Error reading activities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var selected []Activity
+	for _, activity := range activities {
+		if !job.wanted(activity) {
+			continue
+		}
+		if reason := recategorizeSkipReason(activity); reason != "" {
+			log.Printf("\tskipping activity '%s', %s", activity.ActivityId, reason)
+			job.Skipped++
+			continue
+		}
+		selected = append(selected, activity)
+	}
+	job.Total = len(selected)
+
+	// Copied before the job starts, after that it's only safe to read through recategorizations
+	started := job.copy()
+	log.Printf("\tjob '%s' started for %d activities (%d skipped)", started.JobId, started.Total, started.Skipped)
+
+	recategorizations.add(job)
+	go recategorizations.run(job, selected)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(started)
+}
+
+func (j *recategorizeJobs) run(job *RecategorizeJob, activities []Activity) {
+	for _, activity := range activities {
+		change, changed, err := recategorizeOne(activity)
+
+		j.update(job, func(job *RecategorizeJob) {
+			job.Processed++
+			switch {
+			case errors.Is(err, errRecategorizeSkipped):
+				job.Skipped++
+			case err != nil:
+				job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", activity.ActivityId, err))
+			case changed:
+				job.Changed++
+				job.Changes = append(job.Changes, change)
+			default:
+				job.Unchanged++
+			}
+		})
+	}
+
+	j.update(job, func(job *RecategorizeJob) {
+		job.State = recategorizeFinished
+		if len(job.Errors) > 0 && len(job.Errors) == job.Total {
+			job.State = recategorizeFailed
+		}
+		job.FinishedAt = time.Now()
+	})
+
+	log.Printf("activity recategorize - job '%s' %s: %d changed, %d unchanged, %d skipped, %d errors",
+		job.JobId, job.State, job.Changed, job.Unchanged, job.Skipped, len(job.Errors))
+}
+
+var errRecategorizeSkipped = errors.New("activity changed while recategorizing")
+
+// recategorizeOne only re-runs categorization, the duration is left as it was
+func recategorizeOne(activity Activity) (RecategorizeChange, bool, error) {
+	before := newRecategorizeSnapshot(activity)
+
+	// Categorization errors from last time are replaced by this run's
+	activity.ProcessingErrors = slices.DeleteFunc(activity.ProcessingErrors, func(e string) bool {
+		return strings.HasPrefix(e, "categorize: ")
+	})
+	recategorized := categorizeActivity(activity)
+
+	// The job runs for a while, don't overwrite anything that happened meanwhile
+	current, err := activityStore.Get(activity.ActivityId)
+	if errors.Is(err, errActivityNotFound) {
+		return RecategorizeChange{}, false, errRecategorizeSkipped
+	}
+	if err != nil {
+		return RecategorizeChange{}, false, err
+	}
+	if recategorizeSkipReason(current) != "" || current.Jira != activity.Jira || current.CategorizationGrade != activity.CategorizationGrade {
+		log.Printf("\tactivity '%s' changed while recategorizing, leaving it", activity.ActivityId)
+		return RecategorizeChange{}, false, errRecategorizeSkipped
+	}
+
+	if err := activityStore.Update(recategorized); err != nil {
+		return RecategorizeChange{}, false, err
+	}
+
+	after := newRecategorizeSnapshot(recategorized)
+	if after == before {
+		return RecategorizeChange{}, false, nil
+	}
+
+	publishActivity(eventActivityCategorized, recategorized)
+
+	return RecategorizeChange{
+		ActivityId:       activity.ActivityId,
+		InputDescription: activity.InputDescription,
+		Before:           before,
+		After:            after,
+	}, true, nil
+}
+
+func (h *ActivityManager) getRecategorizeJob(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("activity manager - recategorize job request received")
+
+	matches := recategorizeJobById.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "invalid job ID in URL", http.StatusBadRequest)
+		return
+	}
+
+	job, found := recategorizations.get(matches[1])
+	if !found {
+		http.Error(w, "recategorize job not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *ActivityManager) listRecategorizeJobs(w http.ResponseWriter) {
+
+	log.Println("activity manager - recategorize jobs request received")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recategorizations.list())
+}
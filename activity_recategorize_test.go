@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecategorizeOne(t *testing.T) {
+	previous := Activity{
+		ActivityId:             "a1",
+		InputDescription:       "release notes",
+		Project:                "FEDS",
+		Task:                   "Release",
+		Jira:                   "FEDS-148",
+		Categorized:            true,
+		CategorizationGrade:    "A",
+		CategorizationDistance: 0.05,
+		WeaviateId:             "old-rule",
+		RuleDescription:        "release notes",
+	}
+
+	tests := []struct {
+		name      string
+		candidate CandidateRule
+		want      RecategorizeSnapshot
+	}{
+		{
+			"new rule auto applies",
+			CandidateRule{WeaviateId: "r2", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "notes", Distance: 0.04, Grade: "A"},
+			RecategorizeSnapshot{Project: "IZG", Task: "Docs", Jira: "IZG-7", Grade: "A", Distance: 0.04, Categorized: true},
+		},
+		{
+			"low grade leaves it uncategorized",
+			CandidateRule{WeaviateId: "r3", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "release", Distance: 0.3, Grade: "C"},
+			RecategorizeSnapshot{Grade: "C", Distance: 0.3},
+		},
+	}
+
+	defer func(store ActivityStore, chain categorizerChain, grades []string) {
+		activityStore, categorizer, autoGrades = store, chain, grades
+	}(activityStore, categorizer, autoGrades)
+	autoGrades = []string{"A"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := newSqliteActivityStore(filepath.Join(t.TempDir(), "activities.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			activityStore = store
+			categorizer = categorizerChain{stubCategorizer{test.candidate}}
+
+			if err := activityStore.Create(previous); err != nil {
+				t.Fatal(err)
+			}
+
+			change, changed, err := recategorizeOne(previous)
+			if err != nil || !changed {
+				t.Fatalf("recategorizeOne() changed = %t, error = %v", changed, err)
+			}
+			if change.After != test.want {
+				t.Errorf("change.After = %+v, want %+v", change.After, test.want)
+			}
+
+			saved, err := activityStore.Get(previous.ActivityId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if newRecategorizeSnapshot(saved) != test.want || saved.WeaviateId != test.candidate.WeaviateId || saved.RuleDescription != test.candidate.Description {
+				t.Errorf("saved activity %+v doesn't match the change %+v", saved, test.want)
+			}
+		})
+	}
+}
+
+func TestStartRecategorizeSkips(t *testing.T) {
+	newActivityTest(t, CandidateRule{WeaviateId: "r2", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "notes", Distance: 0.04, Grade: "A"})
+
+	uncategorized := func(id string, edit func(*Activity)) Activity {
+		activity := testActivity(id, testDay(14, 9))
+		activity.Project, activity.Task, activity.Jira = "", "", ""
+		activity.Categorized, activity.CategorizationGrade = false, "F"
+		edit(&activity)
+		return activity
+	}
+	for _, activity := range []Activity{
+		uncategorized("a1", func(a *Activity) {}),
+		uncategorized("b1", func(a *Activity) { a.ManuallyEdited = true }),
+		uncategorized("c1", func(a *Activity) { a.PostedToJiraTempo = true }),
+		uncategorized("c2", func(a *Activity) { a.TempoState = tempoStatePending }),
+		uncategorized("d1", func(a *Activity) { a.Status = activityStatusPending }),
+	} {
+		if err := activityStore.Create(activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var job RecategorizeJob
+	if code := activityRequest(t, http.MethodPost, "/api/v1/activity/recategorize?date=20250514&uncategorized=true", "", &job); code != http.StatusAccepted {
+		t.Fatalf("recategorize returned %d", code)
+	}
+	if job.Total != 1 || job.Skipped != 4 {
+		t.Errorf("job started with %d activities and %d skipped, want 1 and 4", job.Total, job.Skipped)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.State == recategorizeRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		job, _ = recategorizations.get(job.JobId)
+	}
+	if job.State != recategorizeFinished || job.Changed != 1 {
+		t.Fatalf("job ended %s with %d changed, want finished with 1", job.State, job.Changed)
+	}
+
+	for _, id := range []string{"a1", "b1", "c1", "c2", "d1"} {
+		saved, err := activityStore.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recategorized := saved.Jira == "IZG-7"; recategorized != (id == "a1") {
+			t.Errorf("activity %s has Jira %q after the job", id, saved.Jira)
+		}
+	}
+}
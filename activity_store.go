@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ActivityStore is where activities get persisted. The handlers in
+// activity_manager.go only talk to this interface so the CSV files and
+// the SQLite database can be swapped with ACTIVITY_STORE.
+type ActivityStore interface {
+	// Create saves a brand-new activity
+	Create(activity Activity) error
+	// Get returns the activity with the id no matter which day it was logged
+	Get(activityId string) (Activity, error)
+	// Update replaces a previously saved activity (matched on ActivityId)
+	Update(activity Activity) error
+	// List returns every activity logged between from and to, both days inclusive
+	List(from time.Time, to time.Time) ([]Activity, error)
+	// Delete removes an activity completely
+	Delete(activityId string) error
+}
+
+var errActivityNotFound = errors.New("activity not found")
+
+var activityStore ActivityStore
+
+func newActivityStore(storeType string) (ActivityStore, error) {
+	switch storeType {
+	case "", "csv":
+		return newCsvActivityStore()
+	case "sqlite":
+		return newSqliteActivityStore(activitySqliteFile)
+	default:
+		return nil, fmt.Errorf("unknown activity store '%s', expected csv or sqlite", storeType)
+	}
+}
+
+// Truncate a time down to midnight so date ranges compare on whole days
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
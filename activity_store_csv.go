@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csvActivityStore is the original storage, one aidea_activity_tracking_YYYYMMDD.csv
+// file per day in the working directory.
+//
+// An index file maps every ActivityId to the day file it lives in so an
+// activity can be found without knowing its date. If the index file is
+// missing it gets rebuilt from the daily files, delete it to force that.
+type csvActivityStore struct {
+	// Handlers run concurrently and updates rewrite the whole file
+	mu sync.Mutex
+	// ActivityId -> YYYYMMDD of the file holding the activity
+	index map[string]string
+}
+
+const activityCsvIndexFile = "aidea_activity_tracking_index.csv"
+
+func newCsvActivityStore() (*csvActivityStore, error) {
+	store := &csvActivityStore{}
+	if err := store.loadIndex(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func activityCsvFilename(date time.Time) string {
+	return fmt.Sprintf("aidea_activity_tracking_%s.csv", date.Format("20060102"))
+}
+
+func (s *csvActivityStore) loadIndex() error {
+	s.index = make(map[string]string)
+
+	file, err := os.Open(activityCsvIndexFile)
+	if os.IsNotExist(err) {
+		return s.rebuildIndex()
+	}
+	if err != nil {
+		return fmt.Errorf("error opening activity index: %v", err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return fmt.Errorf("error reading activity index: %v", err)
+	}
+
+	for _, record := range records {
+		if len(record) == 2 {
+			s.index[record[0]] = record[1]
+		}
+	}
+
+	log.Printf("activity store - loaded %d activity ids from '%s'", len(s.index), activityCsvIndexFile)
+
+	return nil
+}
+
+// Scan every daily file and write a fresh index
+func (s *csvActivityStore) rebuildIndex() error {
+	filenames, err := filepath.Glob("aidea_activity_tracking_[0-9]*.csv")
+	if err != nil {
+		return fmt.Errorf("error finding activity files: %v", err)
+	}
+
+	for _, filename := range filenames {
+		date := strings.TrimSuffix(strings.TrimPrefix(filename, "aidea_activity_tracking_"), ".csv")
+
+		activities, err := readActivityCsv(filename)
+		if err != nil {
+			return err
+		}
+		for _, activity := range activities {
+			s.index[activity.ActivityId] = date
+		}
+	}
+
+	log.Printf("activity store - rebuilt index with %d activity ids from %d files", len(s.index), len(filenames))
+
+	return s.writeIndex()
+}
+
+func (s *csvActivityStore) writeIndex() error {
+	var data bytes.Buffer
+	writer := csv.NewWriter(&data)
+	for activityId, date := range s.index {
+		if err := writer.Write([]string{activityId, date}); err != nil {
+			return fmt.Errorf("error writing activity index: %v", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing activity index: %v", err)
+	}
+
+	// Written to the side and renamed so a crash part way through can't leave half an index
+	temp := activityCsvIndexFile + ".tmp"
+	if err := os.WriteFile(temp, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing activity index: %v", err)
+	}
+	if err := os.Rename(temp, activityCsvIndexFile); err != nil {
+		return fmt.Errorf("error replacing activity index: %v", err)
+	}
+
+	return nil
+}
+
+func (s *csvActivityStore) addToIndex(activityId string, date string) error {
+	file, err := os.OpenFile(activityCsvIndexFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening activity index: %v", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{activityId, date}); err != nil {
+		return fmt.Errorf("error writing activity index: %v", err)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing activity index: %v", err)
+	}
+
+	s.index[activityId] = date
+	return nil
+}
+
+// Work out which daily file holds an activity
+func (s *csvActivityStore) filenameFor(activityId string) (string, error) {
+	date, exists := s.index[activityId]
+	if !exists {
+		return "", fmt.Errorf("%w: %s", errActivityNotFound, activityId)
+	}
+	return fmt.Sprintf("aidea_activity_tracking_%s.csv", date), nil
+}
+
+func (s *csvActivityStore) Create(activity Activity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Activities live in the file for the day the work happened, not when it was submitted
+	date := activity.WorkDate()
+
+	if err := appendActivityCsv(activityCsvFilename(date), activity); err != nil {
+		return err
+	}
+
+	return s.addToIndex(activity.ActivityId, date.Format("20060102"))
+}
+
+func (s *csvActivityStore) Get(activityId string) (Activity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.filenameFor(activityId)
+	if err != nil {
+		return Activity{}, err
+	}
+
+	activities, err := readActivityCsv(filename)
+	if err != nil {
+		return Activity{}, err
+	}
+
+	for _, activity := range activities {
+		if activity.ActivityId == activityId {
+			return activity, nil
+		}
+	}
+
+	return Activity{}, errActivityNotFound
+}
+
+// Update replaces a specific activity in the CSV file it was saved to
+func (s *csvActivityStore) Update(activity Activity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.filenameFor(activity.ActivityId)
+	if err != nil {
+		return err
+	}
+
+	activities, err := readActivityCsv(filename)
+	if err != nil {
+		return err
+	}
+
+	// Find the row with the matching activity ID
+	rowIndex := slices.IndexFunc(activities, func(a Activity) bool {
+		return a.ActivityId == activity.ActivityId
+	})
+	if rowIndex == -1 {
+		return fmt.Errorf("%w: %s in '%s'", errActivityNotFound, activity.ActivityId, filename)
+	}
+
+	// Same day, just replace the row
+	date := activity.WorkDate()
+	if activityCsvFilename(date) == filename {
+		activities[rowIndex] = activity
+		return writeActivityCsv(filename, activities)
+	}
+
+	// The work date changed so the activity moves to another day's file
+	if err := appendActivityCsv(activityCsvFilename(date), activity); err != nil {
+		return err
+	}
+	if err := writeActivityCsv(filename, slices.Delete(activities, rowIndex, rowIndex+1)); err != nil {
+		return err
+	}
+
+	s.index[activity.ActivityId] = date.Format("20060102")
+	return s.writeIndex()
+}
+
+func (s *csvActivityStore) Delete(activityId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.filenameFor(activityId)
+	if err != nil {
+		return err
+	}
+
+	activities, err := readActivityCsv(filename)
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.DeleteFunc(activities, func(a Activity) bool {
+		return a.ActivityId == activityId
+	})
+
+	if err := writeActivityCsv(filename, remaining); err != nil {
+		return err
+	}
+
+	delete(s.index, activityId)
+	return s.writeIndex()
+}
+
+func (s *csvActivityStore) List(from time.Time, to time.Time) ([]Activity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the days that have a file are read, a wide range like from=00010101
+	// would otherwise look for a file for every day in it
+	filenames, err := filepath.Glob("aidea_activity_tracking_[0-9]*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("error finding activity files: %v", err)
+	}
+
+	var activities []Activity
+	for _, filename := range filenames {
+		date := strings.TrimSuffix(strings.TrimPrefix(filename, "aidea_activity_tracking_"), ".csv")
+		day, err := time.ParseInLocation("20060102", date, from.Location())
+		if err != nil || day.Before(startOfDay(from)) || day.After(to) {
+			continue
+		}
+
+		dayActivities, err := readActivityCsv(filename)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, dayActivities...)
+	}
+
+	return activities, nil
+}
+
+// Add a single activity to the end of a daily file, creating it if needed
+func appendActivityCsv(filename string, activity Activity) error {
+	// TODO - output directory as configuration
+	headers, err := readCsvHeaders(filename)
+	if err != nil {
+		return err
+	}
+
+	// If the file was written before the Activity struct gained/lost fields
+	// appending would misalign the columns, so rewrite it with current headers
+	if headers != nil && !slices.Equal(headers, getHeaders(activity)) {
+		activities, err := readActivityCsv(filename)
+		if err != nil {
+			return err
+		}
+		return writeActivityCsv(filename, append(activities, activity))
+	}
+
+	// Open file append mode or create if it doesn't exist
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't open csv file: %v", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+
+	if headers == nil {
+		// Write headers if this file didn't exist already
+		if err := writer.Write(getHeaders(activity)); err != nil {
+			return fmt.Errorf("error writing headers: %v", err)
+		}
+	}
+
+	// Write data in Activity
+	if err := writer.Write(getActivitySlice(activity)); err != nil {
+		return fmt.Errorf("error writing records to csv: %v", err)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing records to csv: %v", err)
+	}
+
+	return nil
+}
+
+// Returns nil headers (and no error) when the file doesn't exist yet
+func readCsvHeaders(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening csv file: %v", err)
+	}
+	defer file.Close()
+
+	headers, err := csv.NewReader(file).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv headers: %v", err)
+	}
+
+	return headers, nil
+}
+
+// Read every activity in a daily file, a missing file is just an empty day
+func readActivityCsv(filename string) ([]Activity, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening csv file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// Older files may have fewer columns than the current Activity struct
+	reader.FieldsPerRecord = -1
+
+	// Read header row
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv headers: %v", err)
+	}
+
+	// Create a map to store header indices for easy lookup
+	headerIndex := make(map[string]int)
+	for i, header := range headers {
+		headerIndex[header] = i
+	}
+
+	var activities []Activity
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv record: %v", err)
+		}
+		activities = append(activities, getActivityFromSlice(headerIndex, record))
+	}
+
+	return activities, nil
+}
+
+func writeActivityCsv(filename string, activities []Activity) error {
+	outFile, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating file for writing: %v", err)
+	}
+	defer outFile.Close()
+
+	writer := csv.NewWriter(outFile)
+
+	if err := writer.Write(getHeaders(Activity{})); err != nil {
+		return fmt.Errorf("error writing headers: %v", err)
+	}
+
+	for _, activity := range activities {
+		if err := writer.Write(getActivitySlice(activity)); err != nil {
+			return fmt.Errorf("error writing updated records to CSV: %v", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
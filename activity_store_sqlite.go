@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteActivityStore keeps every activity in a single embedded SQLite
+// database so months of history can be queried without opening daily files.
+//
+// Columns mirror the Activity struct the same way the CSV headers do, so
+// adding a field to Activity only needs a new column which is added on startup.
+type sqliteActivityStore struct {
+	db *sql.DB
+}
+
+// Activity.WorkDate() in SQL, StartedAt when there is one otherwise CreatedAt.
+// A zero StartedAt is written as year 1 and columns added later are NULL.
+const sqliteWorkDate = `(CASE WHEN "StartedAt" > '0001-01-01 00:00:00' THEN "StartedAt" ELSE "CreatedAt" END)`
+
+func newSqliteActivityStore(filename string) (*sqliteActivityStore, error) {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database '%s': %v", filename, err)
+	}
+
+	// SQLite only allows one writer, keep database/sql from fighting over it
+	db.SetMaxOpenConns(1)
+
+	store := &sqliteActivityStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Printf("activity store - using sqlite database '%s'", filename)
+
+	return store, nil
+}
+
+// Create the activity table or add any columns for Activity fields it doesn't have yet
+func (s *sqliteActivityStore) migrate() error {
+	headers := getHeaders(Activity{})
+
+	columns := make([]string, len(headers))
+	for i, header := range headers {
+		columns[i] = fmt.Sprintf(`"%s" TEXT`, header)
+		if header == "ActivityId" {
+			columns[i] += " PRIMARY KEY"
+		}
+	}
+
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS activity (%s)`, strings.Join(columns, ", ")))
+	if err != nil {
+		return fmt.Errorf("error creating activity table: %v", err)
+	}
+
+	existing, err := s.columns()
+	if err != nil {
+		return err
+	}
+
+	for _, header := range headers {
+		if _, exists := existing[header]; exists {
+			continue
+		}
+		log.Printf("activity store - adding column '%s'", header)
+		_, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE activity ADD COLUMN "%s" TEXT`, header))
+		if err != nil {
+			return fmt.Errorf("error adding column '%s': %v", header, err)
+		}
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS activity_work_date ON activity (` + sqliteWorkDate + `)`)
+	if err != nil {
+		return fmt.Errorf("error creating activity index: %v", err)
+	}
+
+	return nil
+}
+
+func (s *sqliteActivityStore) columns() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_info('activity')`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading activity table columns: %v", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]int)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading activity table columns: %v", err)
+		}
+		columns[name] = len(columns)
+	}
+
+	return columns, rows.Err()
+}
+
+func (s *sqliteActivityStore) Create(activity Activity) error {
+	headers := getHeaders(activity)
+
+	columns := make([]string, len(headers))
+	placeholders := make([]string, len(headers))
+	for i, header := range headers {
+		columns[i] = fmt.Sprintf(`"%s"`, header)
+		placeholders[i] = "?"
+	}
+
+	query := fmt.Sprintf(`INSERT INTO activity (%s) VALUES (%s)`,
+		strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	_, err := s.db.Exec(query, toArgs(getActivitySlice(activity))...)
+	if err != nil {
+		return fmt.Errorf("error inserting activity: %v", err)
+	}
+
+	return nil
+}
+
+// ActivityId is the primary key so it doubles as the global id index
+func (s *sqliteActivityStore) Get(activityId string) (Activity, error) {
+	activities, err := s.query(`WHERE "ActivityId" = ?`, activityId)
+	if err != nil {
+		return Activity{}, err
+	}
+
+	if len(activities) == 0 {
+		return Activity{}, errActivityNotFound
+	}
+
+	return activities[0], nil
+}
+
+func (s *sqliteActivityStore) Update(activity Activity) error {
+	headers := getHeaders(activity)
+
+	assignments := make([]string, len(headers))
+	for i, header := range headers {
+		assignments[i] = fmt.Sprintf(`"%s" = ?`, header)
+	}
+
+	query := fmt.Sprintf(`UPDATE activity SET %s WHERE "ActivityId" = ?`, strings.Join(assignments, ", "))
+
+	args := append(toArgs(getActivitySlice(activity)), activity.ActivityId)
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating activity: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating activity: %v", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: %s", errActivityNotFound, activity.ActivityId)
+	}
+
+	return nil
+}
+
+func (s *sqliteActivityStore) List(from time.Time, to time.Time) ([]Activity, error) {
+	// Times are stored as "2006-01-02 15:04:05" which sorts correctly as text
+	return s.query(`WHERE `+sqliteWorkDate+` >= ? AND `+sqliteWorkDate+` < ? ORDER BY `+sqliteWorkDate,
+		startOfDay(from).Format("2006-01-02 15:04:05"),
+		startOfDay(to).AddDate(0, 0, 1).Format("2006-01-02 15:04:05"))
+}
+
+func (s *sqliteActivityStore) Delete(activityId string) error {
+	result, err := s.db.Exec(`DELETE FROM activity WHERE "ActivityId" = ?`, activityId)
+	if err != nil {
+		return fmt.Errorf("error deleting activity: %v", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting activity: %v", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: %s", errActivityNotFound, activityId)
+	}
+
+	return nil
+}
+
+func (s *sqliteActivityStore) query(where string, args ...interface{}) ([]Activity, error) {
+	rows, err := s.db.Query(`SELECT * FROM activity `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying activities: %v", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("error querying activities: %v", err)
+	}
+
+	headerIndex := make(map[string]int)
+	for i, column := range columns {
+		headerIndex[column] = i
+	}
+
+	var activities []Activity
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("error reading activity row: %v", err)
+		}
+
+		record := make([]string, len(values))
+		for i, value := range values {
+			record[i] = value.String
+		}
+
+		activities = append(activities, getActivityFromSlice(headerIndex, record))
+	}
+
+	return activities, rows.Err()
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
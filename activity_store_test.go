@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Every test runs against both backends, the CSV store works in the current
+// directory so it gets a temporary one
+var testActivityStores = []struct {
+	name string
+	open func(t *testing.T) ActivityStore
+}{
+	{"csv", func(t *testing.T) ActivityStore {
+		t.Chdir(t.TempDir())
+		store, err := newCsvActivityStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}},
+	{"sqlite", func(t *testing.T) ActivityStore {
+		store, err := newSqliteActivityStore(filepath.Join(t.TempDir(), "activities.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}},
+}
+
+func testDay(day int, hour int) time.Time {
+	return time.Date(2025, 5, day, hour, 0, 0, 0, time.Local)
+}
+
+func testActivity(id string, created time.Time) Activity {
+	return Activity{
+		ActivityId:          id,
+		InputDescription:    "1h on FEDS-148 release notes",
+		Project:             "FEDS",
+		Task:                "Release",
+		Jira:                "FEDS-148",
+		Duration:            "1h",
+		TimeSpent:           time.Hour,
+		Categorized:         true,
+		CategorizationGrade: "A",
+		CreatedAt:           created,
+		Status:              activityStatusProcessed,
+	}
+}
+
+func activityIds(activities []Activity) []string {
+	ids := make([]string, len(activities))
+	for i, activity := range activities {
+		ids[i] = activity.ActivityId
+	}
+	return ids
+}
+
+func TestActivityStoreCreateGet(t *testing.T) {
+	for _, backend := range testActivityStores {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+
+			activity := testActivity("a1", testDay(14, 9))
+			activity.StartedAt = testDay(13, 14)
+			activity.EndedAt = testDay(13, 15)
+			activity.ProcessingErrors = []string{"duration: no answer"}
+			if err := store.Create(activity); err != nil {
+				t.Fatal(err)
+			}
+
+			saved, err := store.Get("a1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Jira != activity.Jira || saved.TimeSpent != activity.TimeSpent || !saved.Categorized ||
+				!saved.StartedAt.Equal(activity.StartedAt) || !saved.CreatedAt.Equal(activity.CreatedAt) ||
+				!slices.Equal(saved.ProcessingErrors, activity.ProcessingErrors) {
+				t.Errorf("Get() = %+v, want %+v", saved, activity)
+			}
+
+			if _, err := store.Get("missing"); !errors.Is(err, errActivityNotFound) {
+				t.Errorf("Get(missing) error = %v, want errActivityNotFound", err)
+			}
+		})
+	}
+}
+
+func TestActivityStoreUpdate(t *testing.T) {
+	for _, backend := range testActivityStores {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+
+			activity := testActivity("a1", testDay(14, 9))
+			if err := store.Create(activity); err != nil {
+				t.Fatal(err)
+			}
+
+			activity.Task = "Docs"
+			activity.ManuallyEdited = true
+			if err := store.Update(activity); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := store.Get("a1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Task != "Docs" || !saved.ManuallyEdited {
+				t.Errorf("Get() after Update = %+v, want the edit", saved)
+			}
+
+			// Backdating moves it to the day the work happened
+			activity.StartedAt = testDay(12, 10)
+			activity.EndedAt = testDay(12, 11)
+			if err := store.Update(activity); err != nil {
+				t.Fatal(err)
+			}
+			moved, err := store.List(testDay(12, 0), testDay(12, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := activityIds(moved); !slices.Equal(ids, []string{"a1"}) {
+				t.Errorf("List(12th) = %v, want the backdated activity", ids)
+			}
+			left, err := store.List(testDay(14, 0), testDay(14, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 0 {
+				t.Errorf("List(14th) = %v, want nothing left behind", activityIds(left))
+			}
+
+			if err := store.Update(testActivity("missing", testDay(14, 9))); !errors.Is(err, errActivityNotFound) {
+				t.Errorf("Update(missing) error = %v, want errActivityNotFound", err)
+			}
+		})
+	}
+}
+
+func TestActivityStoreList(t *testing.T) {
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want []string
+	}{
+		{"one day", testDay(13, 0), testDay(13, 0), []string{"b1", "b2"}},
+		{"time of day ignored", testDay(13, 23), testDay(13, 1), []string{"b1", "b2"}},
+		{"two days", testDay(12, 0), testDay(13, 0), []string{"a1", "b1", "b2"}},
+		{"every day since year one", time.Date(1, 1, 1, 0, 0, 0, 0, time.Local), testDay(31, 0), []string{"a1", "b1", "b2", "c1"}},
+		{"no activities", testDay(20, 0), testDay(25, 0), nil},
+		{"backwards", testDay(14, 0), testDay(12, 0), nil},
+	}
+
+	for _, backend := range testActivityStores {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+
+			for _, activity := range []Activity{
+				testActivity("a1", testDay(12, 9)),
+				testActivity("b1", testDay(13, 9)),
+				testActivity("b2", testDay(13, 15)),
+				testActivity("c1", testDay(14, 9)),
+			} {
+				if err := store.Create(activity); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					activities, err := store.List(test.from, test.to)
+					if err != nil {
+						t.Fatal(err)
+					}
+					ids := activityIds(activities)
+					slices.Sort(ids)
+					if len(ids) != len(test.want) || (len(ids) > 0 && !slices.Equal(ids, test.want)) {
+						t.Errorf("List(%s, %s) = %v, want %v", test.from.Format(time.DateOnly), test.to.Format(time.DateOnly), ids, test.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestActivityStoreDelete(t *testing.T) {
+	for _, backend := range testActivityStores {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+
+			for _, id := range []string{"a1", "a2"} {
+				if err := store.Create(testActivity(id, testDay(14, 9))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := store.Delete("a1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get("a1"); !errors.Is(err, errActivityNotFound) {
+				t.Errorf("Get() after Delete error = %v, want errActivityNotFound", err)
+			}
+			if _, err := store.Get("a2"); err != nil {
+				t.Errorf("Get(a2) error = %v, the other activity should still be there", err)
+			}
+			if err := store.Delete("a1"); !errors.Is(err, errActivityNotFound) {
+				t.Errorf("Delete() twice error = %v, want errActivityNotFound", err)
+			}
+		})
+	}
+}
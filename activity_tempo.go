@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/austinmoody/aidea-activity-tracking/tempo"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	activityDayToTempo     *regexp.Regexp
+	activityRangeToTempo   *regexp.Regexp
+	activityTempoReconcile *regexp.Regexp
+)
+
+// What happened to each activity when pushing a day (or range) to Tempo
+const (
+	tempoPosted  = "posted"
+	tempoQueued  = "queued" // didn't go through yet, the outbox will retry it
+	tempoSkipped = "skipped"
+	tempoFailed  = "failed"
+	tempoDryRun  = "dry_run"
+)
+
+type TempoPushResult struct {
+	ActivityId     string         `json:"activity_id"`
+	Jira           string         `json:"jira"`
+	Status         string         `json:"status"`
+	Reason         string         `json:"reason,omitempty"`
+	TempoWorklogId int            `json:"tempo_worklog_id,omitempty"`
+	Worklog        *tempo.Worklog `json:"worklog,omitempty"` // what was (or with dry_run would be) sent
+}
+
+type TempoPushReport struct {
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	DryRun  bool              `json:"dry_run"`
+	Posted  int               `json:"posted"`
+	Queued  int               `json:"queued"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Results []TempoPushResult `json:"results"`
+}
+
+func init() {
+	activityDayToTempo = regexp.MustCompile(`^/api/v1/activity/tempo/([0-9]{8})$`)
+	activityRangeToTempo = regexp.MustCompile(`^/api/v1/activity/tempo/([0-9]{8})/([0-9]{8})$`)
+	activityTempoReconcile = regexp.MustCompile(`^/api/v1/activity/tempo/reconcile/([0-9]{8})$`)
+}
+
+// activitiesToTempo posts every categorized activity not already in Tempo for
+// a day, or a range of days inclusive. One failing doesn't stop the rest, the
+// report says what happened to each. ?dry_run=true only builds the worklogs.
+func (h *ActivityManager) activitiesToTempo(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("activity manager - push to tempo request received: %s", r.URL.Path)
+
+	var fromDate, toDate string
+	if matches := activityDayToTempo.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		fromDate, toDate = matches[1], matches[1]
+	} else if matches := activityRangeToTempo.FindStringSubmatch(r.URL.Path); len(matches) == 3 {
+		fromDate, toDate = matches[1], matches[2]
+	} else {
+		http.Error(w, "Invalid date in URL", http.StatusBadRequest)
+		return
+	}
+
+	from, err := time.Parse("20060102", fromDate)
+	if err != nil {
+		http.Error(w, "invalid date '"+fromDate+"', expected YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse("20060102", toDate)
+	if err != nil {
+		http.Error(w, "invalid date '"+toDate+"', expected YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if to.Before(from) {
+		http.Error(w, "to date must not be before from date", http.StatusBadRequest)
+		return
+	}
+
+	dryRun, err := parseOptionalBool(r.URL.Query(), "dry_run")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activities, err := activityStore.List(from, to)
+	if err != nil {
+		log.Printf("\terror listing activities: %v", err)
+		http.Error(w, "Error reading activities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := TempoPushReport{
+		From:    fromDate,
+		To:      toDate,
+		DryRun:  dryRun != nil && *dryRun,
+		Results: []TempoPushResult{},
+	}
+
+	for _, activity := range activities {
+		result := TempoPushResult{ActivityId: activity.ActivityId, Jira: activity.Jira}
+
+		switch {
+		case activity.PostedToJiraTempo:
+			result.Status = tempoSkipped
+			result.Reason = "already posted"
+			result.TempoWorklogId = activity.TempoWorklogId
+		case !activity.Categorized || activity.Jira == "":
+			result.Status = tempoSkipped
+			result.Reason = "uncategorized"
+		default:
+			result = pushReportedActivity(r, activity, result, report.DryRun)
+		}
+
+		switch result.Status {
+		case tempoPosted:
+			report.Posted++
+		case tempoQueued:
+			report.Queued++
+		case tempoSkipped:
+			report.Skipped++
+		case tempoFailed:
+			report.Failed++
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	log.Printf("\tposted %d, queued %d, skipped %d, failed %d (dry run %t)", report.Posted, report.Queued, report.Skipped, report.Failed, report.DryRun)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+func pushReportedActivity(r *http.Request, activity Activity, result TempoPushResult, dryRun bool) TempoPushResult {
+	if dryRun {
+		worklog, err := newTempoWorklog(r.Context(), activity)
+		if err != nil {
+			result.Status = tempoFailed
+			result.Reason = err.Error()
+			return result
+		}
+		result.Worklog = &worklog
+		result.Status = tempoDryRun
+		return result
+	}
+
+	entry, err := tempoQueue.enqueue(activity)
+	if err == nil {
+		entry, err = tempoQueue.attempt(r.Context(), entry.Key)
+	}
+	result.Worklog = entry.Worklog
+	result.TempoWorklogId = entry.TempoWorklogId
+
+	switch {
+	case err != nil && entry.Key == "":
+		result.Status = tempoFailed
+		result.Reason = err.Error()
+	case entry.State == tempoStateSent:
+		result.Status = tempoPosted
+	case entry.State == tempoStateFailed:
+		result.Status = tempoFailed
+		result.Reason = entry.LastError
+	default:
+		result.Status = tempoQueued
+		result.Reason = entry.LastError
+	}
+
+	return result
+}
+
+// How an activity and Tempo's worklogs line up for a reconcile
+const (
+	reconcileInSync       = "in_sync"
+	reconcileDrifted      = "drifted"
+	reconcileMissing      = "missing_in_tempo" // we think it's posted, Tempo doesn't have it on that date
+	reconcileNotPosted    = "not_posted"
+	reconcileNotInTracker = "not_in_tracker" // a worklog in Tempo no activity knows about
+)
+
+type TempoDrift struct {
+	Field string `json:"field"`
+	Ours  string `json:"ours"`
+	Tempo string `json:"tempo"`
+}
+
+type TempoReconcileItem struct {
+	ActivityId     string       `json:"activity_id,omitempty"`
+	TempoWorklogId int          `json:"tempo_worklog_id,omitempty"`
+	Jira           string       `json:"jira"`
+	Status         string       `json:"status"`
+	Drift          []TempoDrift `json:"drift,omitempty"`
+}
+
+type TempoReconcileReport struct {
+	Date         string               `json:"date"`
+	InSync       int                  `json:"in_sync"`
+	Drifted      int                  `json:"drifted"`
+	Missing      int                  `json:"missing_in_tempo"`
+	NotPosted    int                  `json:"not_posted"`
+	NotInTracker int                  `json:"not_in_tracker"`
+	Items        []TempoReconcileItem `json:"items"`
+}
+
+// reconcileTempo compares a day's activities with the worklogs Tempo has for
+// that day and reports where they disagree. Nothing is changed on either side.
+func (h *ActivityManager) reconcileTempo(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("activity manager - tempo reconcile request received: %s", r.URL.Path)
+
+	matches := activityTempoReconcile.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		http.Error(w, "Invalid date in URL", http.StatusBadRequest)
+		return
+	}
+
+	date, err := time.Parse("20060102", matches[1])
+	if err != nil {
+		http.Error(w, "invalid date '"+matches[1]+"', expected YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+
+	activities, err := activityStore.List(date, date)
+	if err != nil {
+		log.Printf("\terror listing activities: %v", err)
+		http.Error(w, "Error reading activities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	worklogs, err := tempoClient.ListWorklogs(r.Context(), date, date, tempoAuthorAccountId)
+	if err != nil {
+		log.Printf("\terror listing Tempo worklogs: %v", err)
+		http.Error(w, "Error reading Jira/Tempo worklogs: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	byId := make(map[int]tempo.WorklogResponse, len(worklogs))
+	for _, worklog := range worklogs {
+		byId[worklog.TempoWorklogId] = worklog
+	}
+
+	report := TempoReconcileReport{Date: matches[1], Items: []TempoReconcileItem{}}
+
+	for _, activity := range activities {
+		item := TempoReconcileItem{
+			ActivityId:     activity.ActivityId,
+			TempoWorklogId: activity.TempoWorklogId,
+			Jira:           activity.Jira,
+		}
+
+		worklog, found := byId[activity.TempoWorklogId]
+		switch {
+		case !activity.PostedToJiraTempo:
+			item.Status = reconcileNotPosted
+			report.NotPosted++
+		case !found:
+			item.Status = reconcileMissing
+			report.Missing++
+		default:
+			delete(byId, activity.TempoWorklogId)
+			item.Drift = tempoWorklogDrift(r.Context(), activity, worklog)
+			if len(item.Drift) > 0 {
+				item.Status = reconcileDrifted
+				report.Drifted++
+			} else {
+				item.Status = reconcileInSync
+				report.InSync++
+			}
+		}
+
+		report.Items = append(report.Items, item)
+	}
+
+	// Whatever is left in Tempo isn't tied to any of our activities
+	for _, worklog := range worklogs {
+		if _, left := byId[worklog.TempoWorklogId]; !left {
+			continue
+		}
+		report.Items = append(report.Items, TempoReconcileItem{
+			TempoWorklogId: worklog.TempoWorklogId,
+			Jira:           worklog.Issue.Key,
+			Status:         reconcileNotInTracker,
+		})
+		report.NotInTracker++
+	}
+
+	log.Printf("\tin sync %d, drifted %d, missing %d, not posted %d, not in tracker %d",
+		report.InSync, report.Drifted, report.Missing, report.NotPosted, report.NotInTracker)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// The differences between the worklog an activity would make now and the one in Tempo
+func tempoWorklogDrift(ctx context.Context, activity Activity, saved tempo.WorklogResponse) []TempoDrift {
+	ours, err := newTempoWorklog(ctx, activity)
+	if err != nil {
+		return []TempoDrift{{Field: "worklog", Ours: err.Error()}}
+	}
+
+	var drift []TempoDrift
+	compare := func(field string, mine string, theirs string) {
+		if mine != theirs {
+			drift = append(drift, TempoDrift{Field: field, Ours: mine, Tempo: theirs})
+		}
+	}
+
+	// Tempo Cloud only returns the issue id, a key can only be compared to a key
+	if ours.IssueId != 0 && saved.Issue.Id != 0 {
+		compare("issue", strconv.Itoa(ours.IssueId), strconv.Itoa(saved.Issue.Id))
+	} else if ours.IssueKey != "" && saved.Issue.Key != "" {
+		compare("issue", ours.IssueKey, saved.Issue.Key)
+	}
+	compare("time_spent_seconds", strconv.Itoa(ours.TimeSpentSeconds), strconv.Itoa(saved.TimeSpentSeconds))
+	compare("start_date", ours.StartDate, saved.StartDate)
+	compare("description", ours.Description, saved.Description)
+
+	return drift
+}
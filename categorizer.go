@@ -1,110 +1,274 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"log"
 	"slices"
+	"strconv"
+	"strings"
 )
 
-func categorizeActivity(activity Activity) Activity {
-	client, err := weaviate.NewClient(weaviateConfig)
-	if err != nil {
-		fmt.Println(err)
+// CandidateRule is one of the closest rules to an activity description
+type CandidateRule struct {
+	WeaviateId  string  `json:"weaviate_id"`
+	Project     string  `json:"project"`
+	Task        string  `json:"task"`
+	Jira        string  `json:"jira"`
+	Description string  `json:"description"`
+	Distance    float64 `json:"distance"`
+	Grade       string  `json:"grade"`
+	ParentId    string  `json:"parent_id,omitempty"`
+}
+
+// Categorizer finds the rules closest to an activity description, either with
+// Weaviate or the in-process local matcher. Grading and the auto-categorize
+// decision in categorizeActivity are the same for both.
+type Categorizer interface {
+	Name() string
+	// FindCandidates returns the rules closest to description, closest first
+	FindCandidates(ctx context.Context, description string) ([]CandidateRule, error)
+	// Categorize is FindCandidates for a new activity, it also returns the
+	// version of the prompt involved (empty if there wasn't one)
+	Categorize(ctx context.Context, description string) ([]CandidateRule, string, error)
+}
+
+// The categorizers tried in order, see categorizerChain
+var categorizer categorizerChain
+
+func newCategorizer(categorizerType string) (Categorizer, error) {
+	switch categorizerType {
+	case "", "weaviate":
+		return &weaviateCategorizer{}, nil
+	case "local":
+		return localRules, nil
+	default:
+		return nil, fmt.Errorf("unknown categorizer '%s', expected weaviate or local", categorizerType)
 	}
+}
+
+// categorizerChain tries each categorizer in turn (CATEGORIZER_CHAIN, e.g.
+// weaviate,local) until one comes back with candidates and no error. If none
+// do the activity is left uncategorized.
+type categorizerChain []Categorizer
 
-	ctx := context.Background()
-
-	// TODO read systemPrompt from file & make it better
-	systemPrompt := `You are a work time activity categorizer. 
-Find the Activity Description that most matches the supplied string
-Input may include descriptions of how much time was spent on the task, do not include this information
-in your categorization.
-Some concepts which may be helpful:
-IZG means IZ Gateway or Immunization Gateway
-Transformation Service is the same as Xform Service
-Transformation Console is the same as Xform Console
-IZG CC is the IZG Configuration Console or IZ Gateway Configuration Console
-`
-
-	gs := graphql.NewGenerativeSearch().GroupedResult(systemPrompt)
-
-	response, err := client.GraphQL().Get().
-		WithClassName(weaviateClass).
-		WithFields(
-			graphql.Field{Name: "project"},
-			graphql.Field{Name: "task"},
-			graphql.Field{Name: "jira"},
-			graphql.Field{Name: "description"},
-			graphql.Field{Name: "_additional", Fields: []graphql.Field{
-				{Name: "distance"},         // Default weaviate uses cosine.  0 = identical vector / 2 = opposing vector
-				{Name: "id"},               // Internal Weaviate identifier
-				{Name: "creationTimeUnix"}, // Internal Weaviate creation date/time
-			}},
-		).
-		WithGenerativeSearch(gs).
-		WithNearText(
-			client.GraphQL().NearTextArgBuilder().
-				WithConcepts([]string{activity.InputDescription}),
-		).
-		WithLimit(10).
-		Do(ctx)
-
-	if err != nil {
-		panic(err)
+func newCategorizerChain(categorizerTypes []string) (categorizerChain, error) {
+	var chain categorizerChain
+	for _, categorizerType := range categorizerTypes {
+		stage, err := newCategorizer(strings.TrimSpace(categorizerType))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, stage)
+	}
+	return chain, nil
+}
+
+func (c categorizerChain) Name() string {
+	names := make([]string, len(c))
+	for i, stage := range c {
+		names[i] = stage.Name()
 	}
+	return strings.Join(names, ",")
+}
 
-	// Extract data from response
-	data := response.Data["Get"].(map[string]interface{})
-	activityRules := data[weaviateClass].([]interface{})
+// uses reports whether a categorizer type is anywhere in the chain
+func (c categorizerChain) uses(name string) bool {
+	return slices.ContainsFunc(c, func(stage Categorizer) bool { return stage.Name() == name })
+}
 
-	if len(activityRules) > 0 {
-		// Get the first result
-		rule := activityRules[0].(map[string]interface{})
+// categorization is what a run down the chain came up with
+type categorization struct {
+	Candidates    []CandidateRule
+	PromptVersion string
+	Stage         string   // which categorizer found them, categorizedByNone if none did
+	Errors        []string // from every stage that failed
+}
+
+// Recorded on an activity when no stage in the chain found anything
+const categorizedByNone = "none"
+
+func (c categorizerChain) categorize(ctx context.Context, description string) categorization {
+	result := categorization{Stage: categorizedByNone}
+
+	for _, stage := range c {
+		candidates, promptVersion, err := stage.Categorize(ctx, description)
+		if err != nil {
+			log.Printf("\tcategorizer '%s' failed, trying the next: %v", stage.Name(), err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", stage.Name(), err))
+			continue
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		result.Candidates = candidates
+		result.PromptVersion = promptVersion
+		result.Stage = stage.Name()
+		break
+	}
+
+	return result
+}
+
+func (c categorizerChain) FindCandidates(ctx context.Context, description string) ([]CandidateRule, error) {
+	var errs []error
+	for _, stage := range c {
+		candidates, err := stage.FindCandidates(ctx, description)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", stage.Name(), err))
+			continue
+		}
+		if len(candidates) > 0 {
+			return candidates, nil
+		}
+	}
+
+	// Only an error if nothing worked, no rules matching isn't one
+	if len(errs) == len(c) {
+		return nil, errors.Join(errs...)
+	}
+	return nil, nil
+}
+
+func (c categorizerChain) Categorize(ctx context.Context, description string) ([]CandidateRule, string, error) {
+	result := c.categorize(ctx, description)
+	if result.Stage == categorizedByNone && len(result.Errors) == len(c) {
+		return nil, "", errors.New(strings.Join(result.Errors, "; "))
+	}
+	return result.Candidates, result.PromptVersion, nil
+}
 
-		additional := rule["_additional"].(map[string]interface{})
-		distance := additional["distance"].(float64)
-		weaviateId := additional["id"].(string)
+// categorizeActivity finds the closest rule down the categorizer chain and
+// applies it if its grade is good enough. It never fails, if every categorizer
+// errors the activity is just uncategorized with the errors recorded on it.
+func categorizeActivity(activity Activity) Activity {
+	result := categorizer.categorize(context.Background(), activity.InputDescription)
+
+	candidates := result.Candidates
+	activity.PromptVersion = result.PromptVersion
+	activity.CategorizedBy = result.Stage
+	for _, err := range result.Errors {
+		activity.ProcessingErrors = append(activity.ProcessingErrors, "categorize: "+err)
+	}
+
+	// Nothing from an earlier categorization is kept, a low grade this time
+	// leaves the activity uncategorized rather than next to the old project
+	activity.Project = ""
+	activity.Task = ""
+	activity.Jira = ""
+	activity.Categorized = false
+	activity.WeaviateId = ""
+	activity.CategorizationDistance = 0
+
+	if len(candidates) > 0 {
+		// Get the first result
+		rule := candidates[0]
 
 		// Get the grade so we can determine if we want to save the result
-		activity.CategorizationGrade = getCategorizationGrade(distance)
+		activity.CategorizationGrade = rule.Grade
 
-		if slices.Contains(autoGrades, activity.CategorizationGrade) {
+		if slices.Contains(autoCategorizeGrades(rule.Project, rule.Jira), activity.CategorizationGrade) {
 			// We are only going to save this information if the categorization
-			// matches configured grade(s)
-			activity.Project = rule["project"].(string)
-			activity.Task = rule["task"].(string)
-			activity.Jira = rule["jira"].(string)
+			// matches configured grade(s) for the project/Jira it matched
+			activity.Project = rule.Project
+			activity.Task = rule.Task
+			activity.Jira = rule.Jira
 			activity.Categorized = true
 		}
 
 		// Save these no matter what so that user can see what the "closest" match was
-		activity.WeaviateId = weaviateId
-		activity.RuleDescription = rule["description"].(string)
-		activity.CategorizationDistance = distance
-		activity.CategorizationGrade = getCategorizationGrade(distance)
+		activity.WeaviateId = rule.WeaviateId
+		activity.RuleDescription = rule.Description
+		activity.CategorizationDistance = rule.Distance
+
+		// And the runners up so a low grade can be fixed by picking one of them
+		activity.Candidates = candidates[:min(candidateCount, len(candidates))]
 	} else {
-		activity.Categorized = false
 		activity.CategorizationGrade = "N/A"
 		activity.RuleDescription = "N/A"
-		fmt.Printf("No activity category found in response")
+		activity.Candidates = nil
+		log.Printf("\tno activity category found")
 	}
 
 	return activity
 }
 
 func getCategorizationGrade(distance float64) string {
-	switch {
-	case distance >= 0.0 && distance < 0.2:
-		return "A"
-	case distance >= 0.2 && distance < 0.4:
-		return "B"
-	case distance >= 0.4 && distance < 0.7:
-		return "C"
-	case distance >= 0.7 && distance < 1.0:
-		return "D"
-	default:
-		return "F"
+	for _, threshold := range gradeThresholds {
+		if distance >= 0.0 && distance < threshold.MaxDistance {
+			return threshold.Grade
+		}
+	}
+	return "F"
+}
+
+// GradeThreshold is the distance a match has to be under to get a grade
+type GradeThreshold struct {
+	Grade       string
+	MaxDistance float64
+}
+
+// AutoCategorizeOverride changes which grades auto-categorize for one
+// project or Jira key, e.g. sensitive billing codes only taking an A
+type AutoCategorizeOverride struct {
+	Match  string
+	Grades []string
+}
+
+var defaultGradeThresholds = []GradeThreshold{
+	{Grade: "A", MaxDistance: 0.2},
+	{Grade: "B", MaxDistance: 0.4},
+	{Grade: "C", MaxDistance: 0.7},
+	{Grade: "D", MaxDistance: 1.0},
+}
+
+// Parse thresholds like "A:0.2,B:0.4,C:0.7,D:1.0", anything past the last one is an F
+func parseGradeThresholds(value string) ([]GradeThreshold, error) {
+	if strings.TrimSpace(value) == "" {
+		return defaultGradeThresholds, nil
+	}
+
+	var thresholds []GradeThreshold
+	for _, part := range strings.Split(value, ",") {
+		grade, distance, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("grade threshold '%s' should look like A:0.2", part)
+		}
+
+		maxDistance, err := strconv.ParseFloat(distance, 64)
+		if err != nil {
+			return nil, fmt.Errorf("grade threshold '%s' has an invalid distance: %v", part, err)
+		}
+
+		thresholds = append(thresholds, GradeThreshold{
+			Grade:       strings.ToUpper(strings.TrimSpace(grade)),
+			MaxDistance: maxDistance,
+		})
+	}
+
+	// Checked in order so the tightest threshold has to come first
+	slices.SortFunc(thresholds, func(a, b GradeThreshold) int {
+		return cmp.Compare(a.MaxDistance, b.MaxDistance)
+	})
+
+	return thresholds, nil
+}
+
+// The grades that auto-categorize for a rule's project/Jira. A Jira key
+// override beats a project override which beats AUTO_CATEGORIZE_GRADES.
+func autoCategorizeGrades(project string, jira string) []string {
+	for _, override := range autoGradeOverrides {
+		if jira != "" && strings.EqualFold(override.Match, jira) {
+			return override.Grades
+		}
+	}
+	for _, override := range autoGradeOverrides {
+		if project != "" && strings.EqualFold(override.Match, project) {
+			return override.Grades
+		}
 	}
+	return autoGrades
 }
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Words too common to say anything about which rule matches
+var localStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "the": true, "to": true, "was": true, "were": true, "with": true,
+	"i": true, "me": true, "my": true, "we": true, "our": true, "some": true, "about": true,
+}
+
+// localRule is a rule as kept in LOCAL_RULES_FILE, with its embedding if
+// LOCAL_EMBED_ENDPOINT is set so they aren't recomputed on every start
+type localRule struct {
+	Rule
+	Embedding []float64 `json:"embedding,omitempty"`
+}
+
+// localCategorizer matches descriptions against a copy of the rules held in
+// memory, so categorizing doesn't need Weaviate. It uses TF-IDF cosine
+// similarity over the rule descriptions, or the cosine similarity of Ollama
+// embeddings when LOCAL_EMBED_ENDPOINT is set. Either way the distance is
+// 1 - similarity so the usual grade thresholds apply, although they were
+// tuned against Weaviate's distances.
+//
+// The copy is saved to LOCAL_RULES_FILE and refreshed from Weaviate at startup,
+// every LOCAL_RULES_SYNC_INTERVAL and whenever rules are saved.
+type localCategorizer struct {
+	mu sync.RWMutex
+	// Held for a whole sync or upsert so two don't overwrite each other
+	syncMu sync.Mutex
+	file   string
+	rules  []localRule
+	// TF-IDF index over the rule descriptions
+	idf     map[string]float64
+	vectors []map[string]float64
+
+	embedEndpoint string
+	embedModel    string
+	httpClient    *http.Client
+}
+
+var localRules *localCategorizer
+
+func newLocalCategorizer(file string, embedEndpoint string, embedModel string) (*localCategorizer, error) {
+	c := &localCategorizer{
+		file:          file,
+		embedEndpoint: embedEndpoint,
+		embedModel:    embedModel,
+		httpClient:    &http.Client{Timeout: 30 * time.Second},
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("local categorizer - no rules file '%s' yet", file)
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading local rules '%s': %w", file, err)
+	}
+
+	var rules []localRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing local rules '%s': %w", file, err)
+	}
+
+	c.index(rules)
+	log.Printf("local categorizer - loaded %d rules from '%s'", len(rules), file)
+
+	return c, nil
+}
+
+func (c *localCategorizer) Name() string {
+	return "local"
+}
+
+func (c *localCategorizer) Categorize(ctx context.Context, description string) ([]CandidateRule, string, error) {
+	candidates, err := c.FindCandidates(ctx, description)
+	return candidates, "", err
+}
+
+func (c *localCategorizer) FindCandidates(ctx context.Context, description string) ([]CandidateRule, error) {
+	// Asked before taking the lock, it's a network call
+	var queryEmbedding []float64
+	if c.embedEndpoint != "" {
+		var err error
+		queryEmbedding, err = c.embed(ctx, description)
+		if err != nil {
+			log.Printf("\tlocal categorizer - embedding failed, using keywords: %v", err)
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	queryVector := c.tfidf(localTokens(description))
+
+	candidates := make([]CandidateRule, 0, len(c.rules))
+	for i, rule := range c.rules {
+		similarity := cosineSimilarity(queryVector, c.vectors[i])
+		if queryEmbedding != nil && rule.Embedding != nil {
+			similarity = embeddingSimilarity(queryEmbedding, rule.Embedding)
+		}
+
+		// Cosine similarity of embeddings can go negative, keep to Weaviate's 0-2 range
+		distance := min(max(1-similarity, 0), 2)
+
+		candidates = append(candidates, CandidateRule{
+			WeaviateId:  rule.Id,
+			Project:     rule.Project,
+			Task:        rule.Task,
+			Jira:        rule.Jira,
+			Description: rule.Description,
+			Distance:    distance,
+			Grade:       getCategorizationGrade(distance),
+			ParentId:    rule.ParentId,
+		})
+	}
+
+	slices.SortStableFunc(candidates, func(a, b CandidateRule) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+
+	return candidates[:min(len(candidates), max(10, candidateCount))], nil
+}
+
+// Sync replaces the local rules with everything in Weaviate
+func (c *localCategorizer) Sync(ctx context.Context) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	rules, err := fetchAllRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.replace(ctx, rules); err != nil {
+		return err
+	}
+
+	log.Printf("local categorizer - synced %d rules from Weaviate", len(rules))
+	return nil
+}
+
+// Upsert adds or updates rules by id, for rules that were just saved to Weaviate
+func (c *localCategorizer) Upsert(rules []Rule) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	c.mu.RLock()
+	merged := make([]Rule, 0, len(c.rules)+len(rules))
+	for _, existing := range c.rules {
+		merged = append(merged, existing.Rule)
+	}
+	c.mu.RUnlock()
+
+	for _, rule := range rules {
+		i := slices.IndexFunc(merged, func(r Rule) bool { return r.Id == rule.Id })
+		if i >= 0 {
+			merged[i] = rule
+		} else {
+			merged = append(merged, rule)
+		}
+	}
+
+	return c.replace(context.Background(), merged)
+}
+
+// Remove drops rules by id, for rules that were just deleted from Weaviate
+func (c *localCategorizer) Remove(ruleIds ...string) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	c.mu.RLock()
+	remaining := make([]Rule, 0, len(c.rules))
+	for _, existing := range c.rules {
+		if !slices.Contains(ruleIds, existing.Id) {
+			remaining = append(remaining, existing.Rule)
+		}
+	}
+	c.mu.RUnlock()
+
+	return c.replace(context.Background(), remaining)
+}
+
+// syncEvery keeps pulling rules from Weaviate, errors only get logged since
+// the rules already held keep working
+func (c *localCategorizer) syncEvery(interval time.Duration) {
+	for {
+		if err := c.Sync(context.Background()); err != nil {
+			log.Printf("local categorizer - error syncing rules from Weaviate: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (c *localCategorizer) replace(ctx context.Context, rules []Rule) error {
+	c.mu.RLock()
+	previous := make(map[string]localRule, len(c.rules))
+	for _, rule := range c.rules {
+		previous[rule.Id] = rule
+	}
+	c.mu.RUnlock()
+
+	updated := make([]localRule, 0, len(rules))
+	for _, rule := range rules {
+		local := localRule{Rule: rule}
+
+		if c.embedEndpoint != "" {
+			// Only embed descriptions that changed
+			if old, found := previous[rule.Id]; found && old.Description == rule.Description && old.Embedding != nil {
+				local.Embedding = old.Embedding
+			} else if embedding, err := c.embed(ctx, rule.Description); err != nil {
+				log.Printf("local categorizer - error embedding rule '%s': %v", rule.Id, err)
+			} else {
+				local.Embedding = embedding
+			}
+		}
+
+		updated = append(updated, local)
+	}
+
+	c.mu.Lock()
+	c.index(updated)
+	c.mu.Unlock()
+
+	return c.save(updated)
+}
+
+// index rebuilds the TF-IDF vectors, c.mu has to be held
+func (c *localCategorizer) index(rules []localRule) {
+	documents := make([][]string, len(rules))
+	documentFrequency := make(map[string]int)
+	for i, rule := range rules {
+		documents[i] = localTokens(rule.Description)
+
+		seen := make(map[string]bool)
+		for _, token := range documents[i] {
+			if !seen[token] {
+				documentFrequency[token]++
+				seen[token] = true
+			}
+		}
+	}
+
+	// Smoothed so a word in every rule still counts a little
+	c.idf = make(map[string]float64, len(documentFrequency))
+	for token, frequency := range documentFrequency {
+		c.idf[token] = math.Log(float64(len(rules)+1)/float64(frequency+1)) + 1
+	}
+
+	c.rules = rules
+	c.vectors = make([]map[string]float64, len(rules))
+	for i, tokens := range documents {
+		c.vectors[i] = c.tfidf(tokens)
+	}
+}
+
+// A unit length TF-IDF vector, words no rule uses are left out
+func (c *localCategorizer) tfidf(tokens []string) map[string]float64 {
+	vector := make(map[string]float64)
+	for _, token := range tokens {
+		if idf, found := c.idf[token]; found {
+			vector[token] += idf
+		}
+	}
+
+	var norm float64
+	for _, weight := range vector {
+		norm += weight * weight
+	}
+	norm = math.Sqrt(norm)
+	for token := range vector {
+		vector[token] /= norm
+	}
+
+	return vector
+}
+
+// Written to a temp file and renamed so a crash never leaves half a file
+func (c *localCategorizer) save(rules []localRule) error {
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := c.file + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return fmt.Errorf("error writing local rules '%s': %w", temp, err)
+	}
+
+	return os.Rename(temp, c.file)
+}
+
+// embed asks Ollama's /api/embeddings for a vector
+func (c *localCategorizer) embed(ctx context.Context, text string) ([]float64, error) {
+	requestData, err := json.Marshal(map[string]string{"model": c.embedModel, "prompt": text})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.embedEndpoint, bytes.NewBuffer(requestData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama API returned error: %s - %s", resp.Status, string(responseBody))
+	}
+
+	var embeddingResponse struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	if err := json.Unmarshal(responseBody, &embeddingResponse); err != nil {
+		return nil, fmt.Errorf("error processing Ollama response: %w", err)
+	}
+	if len(embeddingResponse.Embedding) == 0 {
+		return nil, fmt.Errorf("Ollama returned an empty embedding")
+	}
+
+	return embeddingResponse.Embedding, nil
+}
+
+// Lower case words and numbers, a Jira key like FEDS-148 becomes feds and 148
+func localTokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := words[:0]
+	for _, word := range words {
+		if len(word) > 1 && !localStopWords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// Both vectors are already unit length
+func cosineSimilarity(a map[string]float64, b map[string]float64) float64 {
+	var dot float64
+	for token, weight := range a {
+		dot += weight * b[token]
+	}
+	return dot
+}
+
+func embeddingSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+)
+
+// stubCategorizer always answers with the same candidates
+type stubCategorizer []CandidateRule
+
+func (s stubCategorizer) Name() string {
+	return "stub"
+}
+
+func (s stubCategorizer) FindCandidates(ctx context.Context, description string) ([]CandidateRule, error) {
+	return s, nil
+}
+
+func (s stubCategorizer) Categorize(ctx context.Context, description string) ([]CandidateRule, string, error) {
+	return s, "", nil
+}
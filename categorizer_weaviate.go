@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"log"
+)
+
+// weaviateCategorizer finds rules with a Weaviate nearText query over the
+// rule vectors, the original (and default) way of categorizing
+type weaviateCategorizer struct{}
+
+func (c *weaviateCategorizer) Name() string {
+	return "weaviate"
+}
+
+func (c *weaviateCategorizer) FindCandidates(ctx context.Context, description string) ([]CandidateRule, error) {
+	return findCandidateRules(ctx, description, nil)
+}
+
+func (c *weaviateCategorizer) Categorize(ctx context.Context, description string) ([]CandidateRule, string, error) {
+	// The generative grouped result isn't used for anything yet, if the prompt
+	// can't be loaded still categorize on distance alone
+	var gs *graphql.GenerativeSearchBuilder
+	systemPrompt, promptVersion, err := renderPrompt("categorizer.tmpl")
+	if err != nil {
+		log.Printf("\terror loading categorizer prompt: %v", err)
+	} else {
+		gs = graphql.NewGenerativeSearch().GroupedResult(systemPrompt)
+	}
+
+	candidates, err := findCandidateRules(ctx, description, gs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return candidates, promptVersion, nil
+}
+
+// findCandidateRules asks Weaviate for the rules closest to a description,
+// closest first. gs is optional.
+func findCandidateRules(ctx context.Context, description string, gs *graphql.GenerativeSearchBuilder) ([]CandidateRule, error) {
+	client, err := weaviate.NewClient(weaviateConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	query := client.GraphQL().Get().
+		WithClassName(weaviateClass).
+		WithFields(
+			graphql.Field{Name: "project"},
+			graphql.Field{Name: "task"},
+			graphql.Field{Name: "jira"},
+			graphql.Field{Name: "description"},
+			graphql.Field{Name: "parentId"},
+			graphql.Field{Name: "_additional", Fields: []graphql.Field{
+				{Name: "distance"},         // Default weaviate uses cosine.  0 = identical vector / 2 = opposing vector
+				{Name: "id"},               // Internal Weaviate identifier
+				{Name: "creationTimeUnix"}, // Internal Weaviate creation date/time
+			}},
+		).
+		WithNearText(
+			client.GraphQL().NearTextArgBuilder().
+				WithConcepts([]string{description}),
+		).
+		WithLimit(max(10, candidateCount))
+
+	if gs != nil {
+		query = query.WithGenerativeSearch(gs)
+	}
+
+	response, err := query.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Errors) > 0 {
+		return nil, fmt.Errorf("weaviate query error: %s", response.Errors[0].Message)
+	}
+
+	// Extract data from response, anything unexpected is an error so the chain
+	// can fall back to the next categorizer
+	data, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("weaviate response has no Get results")
+	}
+	activityRules, ok := data[weaviateClass].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("weaviate response has no %s results", weaviateClass)
+	}
+
+	candidates := make([]CandidateRule, 0, len(activityRules))
+	for i, activityRule := range activityRules {
+		rule, ok := activityRule.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("weaviate result %d isn't an object", i)
+		}
+		additional, ok := rule["_additional"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("weaviate result %d has no _additional fields", i)
+		}
+		distance, ok := additional["distance"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("weaviate result %d has no distance", i)
+		}
+		id, ok := additional["id"].(string)
+		if !ok {
+			return nil, fmt.Errorf("weaviate result %d has no id", i)
+		}
+
+		candidates = append(candidates, CandidateRule{
+			WeaviateId:  id,
+			Project:     stringProperty(rule, "project"),
+			Task:        stringProperty(rule, "task"),
+			Jira:        stringProperty(rule, "jira"),
+			Description: stringProperty(rule, "description"),
+			Distance:    distance,
+			Grade:       getCategorizationGrade(distance),
+			ParentId:    stringProperty(rule, "parentId"),
+		})
+	}
+
+	return candidates, nil
+}
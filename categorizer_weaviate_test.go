@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFindCandidateRulesResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		wantError bool
+	}{
+		{"rules", `{"data": {"Get": {"Rule": [{"project": "FEDS", "task": "Release", "jira": "FEDS-148", "description": "release notes", "parentId": null, "_additional": {"distance": 0.05, "id": "r1"}}]}}}`, false},
+		{"no Get", `{"data": {}}`, true},
+		{"no class", `{"data": {"Get": {"Other": []}}}`, true},
+		{"not an object", `{"data": {"Get": {"Rule": ["release notes"]}}}`, true},
+		{"no additional", `{"data": {"Get": {"Rule": [{"project": "FEDS"}]}}}`, true},
+		{"distance as text", `{"data": {"Get": {"Rule": [{"_additional": {"distance": "0.05", "id": "r1"}}]}}}`, true},
+		{"no id", `{"data": {"Get": {"Rule": [{"_additional": {"distance": 0.05}}]}}}`, true},
+	}
+
+	defer func(config weaviate.Config, class string) { weaviateConfig, weaviateClass = config, class }(weaviateConfig, weaviateClass)
+	weaviateClass = "Rule"
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+			weaviateConfig = weaviate.Config{Host: strings.TrimPrefix(server.URL, "http://"), Scheme: "http"}
+
+			candidates, err := findCandidateRules(context.Background(), "release notes", nil)
+			if (err != nil) != test.wantError {
+				t.Fatalf("findCandidateRules() error = %v, want error %t", err, test.wantError)
+			}
+			if err == nil && (len(candidates) != 1 || candidates[0].WeaviateId != "r1" || candidates[0].Jira != "FEDS-148") {
+				t.Errorf("findCandidateRules() = %+v, want rule r1", candidates)
+			}
+		})
+	}
+}
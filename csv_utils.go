@@ -1,55 +1,14 @@
 package main
 
 import (
-	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"os"
+	"log"
 	"reflect"
+	"strconv"
 	"time"
 )
 
-func saveActivityCsv(activity Activity) error {
-
-	// TODO - output directory as configuration
-	// TODO - save in some kind of data store
-
-	// Generate filename based on current date
-	currentDate := time.Now().Format("20060102") // Format for YYYYMMDD
-	filename := fmt.Sprintf("aidea_activity_tracking_%s.csv", currentDate)
-
-	// Check if the file exists to determine if we need to write headers
-	fileExists := false
-	if _, err := os.Stat(filename); err == nil {
-		fileExists = true
-	}
-
-	// Open file append mode or create if it doesn't exist
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("couldn't open csv file: %v", err)
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	if !fileExists {
-		// Write headers if this file didn't exist already
-		if err := writer.Write(getHeaders(activity)); err != nil {
-			return fmt.Errorf("error writing headers: %v", err)
-		}
-	}
-
-	// Write data in Activity
-	myValues := getActivitySlice(activity)
-
-	if err := writer.Write(myValues); err != nil {
-		return fmt.Errorf("error writing records to csv: %v", err)
-	}
-
-	return nil
-}
-
 func getRuleHeaders(rule Rule) []string {
 	ruleType := reflect.TypeOf(rule)
 
@@ -130,6 +89,12 @@ func getActivitySlice(activity Activity) []string {
 			}
 		case reflect.Bool:
 			activityValues[i] = fmt.Sprintf("%t", field.Bool())
+		case reflect.Slice:
+			// Lists (e.g. candidate rules) are kept as JSON in a single column
+			if field.Len() > 0 {
+				value, _ := json.Marshal(field.Interface())
+				activityValues[i] = string(value)
+			}
 		// Add other types as needed
 		default:
 			activityValues[i] = fmt.Sprintf("%v", field.Interface())
@@ -137,3 +102,68 @@ func getActivitySlice(activity Activity) []string {
 	}
 	return activityValues
 }
+
+// Take a CSV record (or database row) and convert it back to an Activity.
+// headerIndex maps the Activity field name to its position in the record,
+// fields missing from the record are left at their zero value.
+func getActivityFromSlice(headerIndex map[string]int, record []string) Activity {
+	activity := Activity{}
+	activityValue := reflect.ValueOf(&activity).Elem()
+	activityType := reflect.TypeOf(activity)
+
+	// Map CSV values to struct fields
+	for i := 0; i < activityType.NumField(); i++ {
+		fieldName := activityType.Field(i).Name
+		if idx, exists := headerIndex[fieldName]; exists && idx < len(record) {
+			field := activityValue.FieldByName(fieldName)
+			if field.CanSet() {
+				// Set value based on field type
+				switch field.Kind() {
+				case reflect.String:
+					field.SetString(record[idx])
+				case reflect.Float64:
+					val, _ := strconv.ParseFloat(record[idx], 64)
+					field.SetFloat(val)
+				case reflect.Bool:
+					val, _ := strconv.ParseBool(record[idx])
+					field.SetBool(val)
+				case reflect.Slice:
+					if record[idx] != "" {
+						if err := json.Unmarshal([]byte(record[idx]), field.Addr().Interface()); err != nil {
+							log.Printf("Error parsing %s from '%s': %v", fieldName, record[idx], err)
+						}
+					}
+				case reflect.Int, reflect.Int64:
+					// time.Duration is written with its String() e.g. 1h15m0s
+					if field.Type() == reflect.TypeOf(time.Duration(0)) {
+						val, _ := time.ParseDuration(record[idx])
+						field.SetInt(int64(val))
+					} else {
+						val, _ := strconv.ParseInt(record[idx], 10, 64)
+						field.SetInt(val)
+					}
+				case reflect.Struct:
+					// Handle time.Time, an empty value is a column added after the row was written
+					if field.Type() == reflect.TypeOf(time.Time{}) && record[idx] != "" {
+						// First try our simplified format (what we're writing to CSV now)
+						t, err := time.Parse("2006-01-02 15:04:05", record[idx])
+						if err != nil {
+							// Try RFC3339 format
+							t, err = time.Parse(time.RFC3339, record[idx])
+							if err != nil {
+								// Try the original verbose format for backward compatibility
+								t, err = time.Parse("2006-01-02 15:04:05.999999 -0700 MST m=+0.000000000", record[idx])
+								if err != nil {
+									log.Printf("Error parsing time from '%s': %v", record[idx], err)
+								}
+							}
+						}
+						field.Set(reflect.ValueOf(t))
+					}
+				}
+			}
+		}
+	}
+
+	return activity
+}
@@ -1,189 +1,372 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-type OllamaRequest struct {
-	Model       string  `json:"model"`
-	Prompt      string  `json:"prompt"`
-	System      string  `json:"system"`
-	Stream      bool    `json:"stream"`
-	MaxTokens   int     `json:"max_tokens,omitempty"`
-	Temperature float64 `json:"temperature,omitempty"`
-}
+// What gets logged when the description doesn't mention any time spent
+const defaultDuration = 15 * time.Minute
+
+var (
+	// "half an hour", "an hour", "a couple of hours" etc. get rewritten to
+	// numbers first so the unit patterns below only have to deal with digits
+	durationPhrases = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`\b(an? )?hour and a half\b`), "90 minutes"},
+		{regexp.MustCompile(`\b(\d+) and a half (hours?|hrs?)\b`), "$1.5 hours"},
+		{regexp.MustCompile(`\bhalf (an |a )?(hour|hr)\b`), "30 minutes"},
+		{regexp.MustCompile(`\b(a )?quarter (of )?(an )?(hour|hr)\b`), "15 minutes"},
+		{regexp.MustCompile(`\ba couple (of )?(hours|hrs)\b`), "2 hours"},
+		{regexp.MustCompile(`\ban? (hour|hr)\b`), "1 hour"},
+	}
 
-type OllamaResponse struct {
-	Model    string `json:"model"`
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
-}
+	durationNumberWords = map[string]string{
+		"one": "1", "two": "2", "three": "3", "four": "4", "five": "5", "six": "6",
+		"seven": "7", "eight": "8", "nine": "9", "ten": "10", "fifteen": "15",
+		"twenty": "20", "thirty": "30", "forty": "40", "forty-five": "45",
+		"forty five": "45", "sixty": "60", "ninety": "90",
+	}
+	durationNumberWord = regexp.MustCompile(`\b(forty[- ]five|one|two|three|four|five|six|seven|eight|nine|ten|fifteen|twenty|thirty|forty|sixty|ninety)\s+(hours?|hrs?|minutes?|mins?)\b`)
 
-// TODO - generic Ollama function to pass in system prompt, user input and get response
+	// 2h, 1.5 hrs, 3 hours, also 2h15 and 1hr30min where the minutes follow straight on
+	durationHours = regexp.MustCompile(`(\d+(?:[.,]\d+)?)\s*(?:hours?|hrs?|h)(?:(\d{1,2})(?:minutes?|mins?|m)?\b|\b)`)
+	// 45m, 90 minutes, 20 mins. Not part of a bigger number, so "v2.5m" isn't 5 minutes
+	durationMinutes = regexp.MustCompile(`(?:^|[^\d.,])(\d+(?:[.,]\d+)?)\s*(minutes?|mins?|m)\b`)
 
-func getDuration(activity Activity) (string, error) {
+	// 9:30-11:00, 2-4pm, 2pm to 4pm, from 9 to 10:30. A range needs a colon, am/pm
+	// or "from" so that "2-3 hours" isn't read as a clock range
+	durationClockRange = regexp.MustCompile(`(from\s+)?\b(\d{1,2})(?::(\d{2}))?\s*(am|pm)?\s*(?:-|–|to|until|till)\s*(\d{1,2})(?::(\d{2}))?\s*(am|pm)?\b`)
 
-	systemPrompt := `You are a time duration extractor. Your ONLY job is to output a time duration in the format below.
+	// Anything that suggests a duration was given even if the parser couldn't make sense of it
+	durationMentioned = regexp.MustCompile(`\d\s*(h|m)|hour|hr|min|half|quarter|all day|all morning|all afternoon`)
+)
 
-CRITICAL INSTRUCTIONS:
-1. NEVER include any explanations, questions, or additional text in your response
-2. ONLY output the final time duration and nothing else
-3. DO NOT respond conversationally under any circumstances
-4. Your ENTIRE response must be JUST the duration string
+// parseDuration turns the usual ways of saying how long something took into
+// a duration. The second return value is false when nothing usable was found.
+func parseDuration(input string) (time.Duration, bool) {
+	text := strings.ToLower(input)
 
-Format rules:
-- ALWAYS OUTPUT EXACTLY "15m" if no specific time is mentioned in the input
-- For specific time mentions, convert to the format "Xh Ym" where X is hours and Y is minutes
-- For hours + minutes format:
-  - Example: 75 minutes = "1h 15m"
-  - Example: 90 minutes = "1h 30m" 
-  - Example: 120 minutes = "2h"
-  - Example: 150 minutes = "2h 30m"
-- For minutes only (less than one hour):
-  - Example: 30 minutes = "30m"
-  - Example: 45 minutes = "45m"
-- For exact hours:
-  - Example: 2 hours = "2h"
-  - Example: 1 hour = "1h"
+	// A clock range says exactly how long it was, nothing else to add up
+	if start, end, ok := parseClockRange(text); ok {
+		return end - start, true
+	}
 
-Examples:
-Input: "Working on project for 30 minutes"
-Output: 30m
+	text = durationNumberWord.ReplaceAllStringFunc(text, func(match string) string {
+		parts := durationNumberWord.FindStringSubmatch(match)
+		return strings.Replace(match, parts[1], durationNumberWords[parts[1]], 1)
+	})
+	for _, phrase := range durationPhrases {
+		text = phrase.pattern.ReplaceAllString(text, phrase.replacement)
+	}
 
-Input: "Spent 2 hours on bug fixes"
-Output: 2h
+	var total time.Duration
+	found := false
+
+	for _, match := range durationHours.FindAllStringSubmatch(text, -1) {
+		hours, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+		if err != nil {
+			continue
+		}
+		total += time.Duration(hours * float64(time.Hour))
+		if match[2] != "" {
+			minutes, _ := strconv.Atoi(match[2])
+			total += time.Duration(minutes) * time.Minute
+		}
+		found = true
+	}
 
-Input: "Meeting lasted 1 hour and 15 minutes"
-Output: 1h 15m
+	// Take the hours out so "2h15m" doesn't count the 15m twice
+	text = durationHours.ReplaceAllString(text, " ")
+
+	for _, match := range durationMinutes.FindAllStringSubmatch(text, -1) {
+		// A bare m after a decimal is more likely "2.5m" users or dollars than minutes
+		if match[2] == "m" && strings.ContainsAny(match[1], ".,") {
+			continue
+		}
+		minutes, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+		if err != nil {
+			continue
+		}
+		total += time.Duration(minutes * float64(time.Minute))
+		found = true
+	}
 
-Input: "Working on AIdea"
-Output: 15m
+	if !found || total <= 0 || total > 24*time.Hour {
+		return 0, false
+	}
 
-Input: "Coding the new feature"
-Output: 15m`
+	return total.Round(time.Minute), true
+}
 
-	ollamaRequest := OllamaRequest{
-		Model:       ollamaGenModel,
-		Prompt:      activity.InputDescription,
-		System:      systemPrompt,
-		Stream:      false,
-		MaxTokens:   2000,
-		Temperature: 0.7,
+// Returns the start and end of the first clock range as offsets from midnight.
+// Every match is tried so a date like 2025-05-13 ("05-13") doesn't hide the
+// range that follows it.
+func parseClockRange(text string) (time.Duration, time.Duration, bool) {
+	for _, match := range durationClockRange.FindAllStringSubmatch(text, -1) {
+		if start, end, ok := clockRange(match); ok {
+			return start, end, true
+		}
 	}
+	return 0, 0, false
+}
 
-	requestData, err := json.Marshal(ollamaRequest)
-	if err != nil {
-		return "", fmt.Errorf("error marshalling request: %w", err)
+func clockRange(match []string) (time.Duration, time.Duration, bool) {
+	from, startHour, startMinute, startMeridiem := match[1], match[2], match[3], match[4]
+	endHour, endMinute, endMeridiem := match[5], match[6], match[7]
+
+	if from == "" && startMinute == "" && endMinute == "" && startMeridiem == "" && endMeridiem == "" {
+		return 0, 0, false
 	}
 
-	req, err := http.NewRequest("POST", ollamaGenEndpoint, bytes.NewBuffer(requestData))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
+	// "2-4pm" means both ends are pm
+	if startMeridiem == "" {
+		startMeridiem = endMeridiem
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	start, ok := clockOffset(startHour, startMinute, startMeridiem)
+	if !ok {
+		return 0, 0, false
+	}
+	end, ok := clockOffset(endHour, endMinute, endMeridiem)
+	if !ok {
+		return 0, 0, false
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request to Ollama: %w", err)
+	// No am/pm and the end is before the start, e.g. 11-1 means 11am to 1pm
+	if end <= start && endMeridiem == "" {
+		end += 12 * time.Hour
+	}
+	// "11-1pm" picked up pm for the start as well
+	if end <= start && start >= 12*time.Hour {
+		start -= 12 * time.Hour
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		responseBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Ollama API returned error: %s - %s", resp.Status, string(responseBody))
+	if end <= start || end-start > 24*time.Hour {
+		return 0, 0, false
 	}
 
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
+	return start, end, true
+}
+
+func clockOffset(hourText string, minuteText string, meridiem string) (time.Duration, bool) {
+	hour, err := strconv.Atoi(hourText)
+	if err != nil || hour > 23 {
+		return 0, false
 	}
 
-	var ollamaResponse OllamaResponse
-	err = json.Unmarshal(responseBody, &ollamaResponse)
-	if err != nil {
-		return "", fmt.Errorf("error processing Ollama response: %w", err)
+	minute := 0
+	if minuteText != "" {
+		minute, err = strconv.Atoi(minuteText)
+		if err != nil || minute > 59 {
+			return 0, false
+		}
 	}
 
-	return ollamaResponse.Response, nil
+	switch meridiem {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	}
 
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, true
 }
 
-func getDurationInSeconds(activity Activity) (int, error) {
+var (
+	workDateYesterday = regexp.MustCompile(`\b(the )?day before yesterday\b|\byesterday\b`)
+	workDateWeekday   = regexp.MustCompile(`\b(last|on|this past) (monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+	workDateIso       = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b`)
+	workPartOfDay     = regexp.MustCompile(`\b(morning|afternoon|evening|night)\b`)
+	// A single start time "at 2pm", "at 14:30"
+	workStartTime = regexp.MustCompile(`\bat (\d{1,2})(?::(\d{2}))?\s*(am|pm)?\b`)
+
+	weekdays = map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+		"wednesday": time.Wednesday, "thursday": time.Thursday, "friday": time.Friday,
+		"saturday": time.Saturday,
+	}
 
-	systemPrompt := `You are a time duration extractor. Your ONLY job is to output a time duration in seconds.
-CRITICAL INSTRUCTIONS:
-1. NEVER include any explanations, questions, or additional text in your response
-2. ONLY output the final time duration in seconds and nothing else
-3. DO NOT respond conversationally under any circumstances
-4. Your ENTIRE response must be JUST the duration integer in seconds
+	// Where "this morning" etc. start when no clock time is given
+	partOfDayStart = map[string]time.Duration{
+		"morning":   9 * time.Hour,
+		"afternoon": 13 * time.Hour,
+		"evening":   18 * time.Hour,
+		"night":     19 * time.Hour,
+	}
+)
 
-The time format you will receive is "Xh Ym" where X is hours and Y is minutes.  
+// parseWorkTime pulls the actual time the work happened out of a description,
+// "yesterday 2-4pm", "last Friday", "this morning for an hour". now is when the
+// activity was submitted and duration is what getDuration came up with. The
+// last return value is false when the description doesn't say when the work
+// happened, in which case it's assumed to be just now.
+func parseWorkTime(input string, duration time.Duration, now time.Time) (time.Time, time.Time, bool) {
+	text := strings.ToLower(input)
 
-If the duration only contains minutes you would only receive Ym
+	day, dayFound := parseWorkDate(text, now)
+	midnight := startOfDay(day)
 
-If the duration only contains hours you would only receive Xh
+	// Exact clock range beats everything else
+	if start, end, ok := parseClockRange(text); ok {
+		return midnight.Add(start), midnight.Add(end), true
+	}
 
-You will need to convert this to seconds
+	if match := workStartTime.FindStringSubmatch(text); match != nil && (match[2] != "" || match[3] != "") {
+		if offset, ok := clockOffset(match[1], match[2], match[3]); ok {
+			start := midnight.Add(offset)
+			return start, start.Add(duration), true
+		}
+	}
 
-So if you received 30m you would return 1800
+	if match := workPartOfDay.FindStringSubmatch(text); match != nil {
+		start := midnight.Add(partOfDayStart[match[1]])
+		return start, start.Add(duration), true
+	}
 
-If you received 1h you would return 3600
+	if dayFound {
+		// Only know the day, keep the time of day it was submitted
+		end := time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), 0, 0, now.Location())
+		return end.Add(-duration), end, true
+	}
 
-If you received 2h 15m you would return 8100
-`
-	ollamaRequest := OllamaRequest{
-		Model:       ollamaGenModel,
-		Prompt:      activity.Duration,
-		System:      systemPrompt,
-		Stream:      false,
-		MaxTokens:   2000,
-		Temperature: 0.7,
+	return time.Time{}, time.Time{}, false
+}
+
+// Works out which day a description is talking about, defaults to the day of now
+func parseWorkDate(text string, now time.Time) (time.Time, bool) {
+	if match := workDateIso.FindString(text); match != "" {
+		if date, err := time.ParseInLocation("2006-01-02", match, now.Location()); err == nil {
+			return date, true
+		}
 	}
 
-	requestData, err := json.Marshal(ollamaRequest)
-	if err != nil {
-		return -1, fmt.Errorf("error marshalling request: %w", err)
+	if match := workDateYesterday.FindString(text); match != "" {
+		if strings.Contains(match, "before") {
+			return now.AddDate(0, 0, -2), true
+		}
+		return now.AddDate(0, 0, -1), true
 	}
 
-	req, err := http.NewRequest("POST", ollamaGenEndpoint, bytes.NewBuffer(requestData))
-	if err != nil {
-		return -1, fmt.Errorf("error creating request: %w", err)
+	if match := workDateWeekday.FindStringSubmatch(text); match != nil {
+		weekday := weekdays[match[2]]
+		daysAgo := (int(now.Weekday()) - int(weekday) + 7) % 7
+		// "last friday" on a friday is a week ago, "on friday" is today
+		if daysAgo == 0 && match[1] != "on" {
+			daysAgo = 7
+		}
+		return now.AddDate(0, 0, -daysAgo), true
+	}
+
+	if strings.Contains(text, "today") {
+		return now, true
+	}
+
+	return now, false
+}
+
+// Format a duration the way Jira/Tempo display it: "1h 15m", "2h" or "30m"
+func formatDuration(duration time.Duration) string {
+	totalMinutes := int(math.Round(duration.Minutes()))
+	hours, minutes := totalMinutes/60, totalMinutes%60
+
+	switch {
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// Where an activity's duration came from
+const (
+	durationSourceParsed   = "parsed"   // the native parser read it from the description
+	durationSourceDefault  = "default"  // no time was mentioned
+	durationSourceLLM      = "llm"      // the LLM worked it out
+	durationSourceFallback = "fallback" // the LLM failed so it's the default
+)
+
+// getDuration works out how long was spent from the activity description and
+// says where the answer came from. The native parser handles the usual
+// phrasings, the LLM is only asked when the description mentions time in a
+// way the parser couldn't follow. The duration prompt's version is returned
+// whenever the LLM was asked, even if its answer couldn't be used.
+func getDuration(activity Activity) (time.Duration, string, string, error) {
+	if duration, ok := parseDuration(activity.InputDescription); ok {
+		return duration, durationSourceParsed, "", nil
+	}
+
+	if !durationMentioned.MatchString(strings.ToLower(activity.InputDescription)) {
+		return defaultDuration, durationSourceDefault, "", nil
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	log.Printf("\tduration not parsed natively, asking the LLM")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	response, promptVersion, err := getDurationFromLLM(activity)
 	if err != nil {
-		return -1, fmt.Errorf("error sending request to Ollama: %w", err)
+		return 0, "", promptVersion, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		responseBody, _ := io.ReadAll(resp.Body)
-		return -1, fmt.Errorf("Ollama API returned error: %s - %s", resp.Status, string(responseBody))
+	duration, ok := parseDuration(response)
+	if !ok {
+		return 0, "", promptVersion, fmt.Errorf("unable to read a duration from LLM response '%s'", strings.TrimSpace(response))
 	}
 
-	responseBody, err := io.ReadAll(resp.Body)
+	return duration, durationSourceLLM, promptVersion, nil
+}
+
+// getDurationInSeconds returns the time spent on an activity in seconds for Jira/Tempo
+func getDurationInSeconds(activity Activity) (int, error) {
+	if activity.TimeSpent > 0 {
+		return int(activity.TimeSpent.Seconds()), nil
+	}
+
+	// Older activities only have the display string
+	if duration, ok := parseDuration(activity.Duration); ok {
+		return int(duration.Seconds()), nil
+	}
+
+	return getDurationInSecondsFromLLM(activity)
+}
+
+// Returns the LLM's answer and the version of duration.tmpl it was asked with
+func getDurationFromLLM(activity Activity) (string, string, error) {
+
+	systemPrompt, promptVersion, err := renderPrompt("duration.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+
+	response, err := generateWithLLM(systemPrompt, activity.InputDescription)
+	return response, promptVersion, err
+}
+
+func getDurationInSecondsFromLLM(activity Activity) (int, error) {
+
+	systemPrompt, _, err := renderPrompt("duration_seconds.tmpl")
 	if err != nil {
-		return -1, fmt.Errorf("error reading response body: %w", err)
+		return -1, err
 	}
 
-	var ollamaResponse OllamaResponse
-	err = json.Unmarshal(responseBody, &ollamaResponse)
+	response, err := generateWithLLM(systemPrompt, activity.Duration)
 	if err != nil {
-		return -1, fmt.Errorf("error processing Ollama response: %w", err)
+		return -1, err
 	}
 
-	durationAsString := strings.TrimSuffix(ollamaResponse.Response, "\n")
+	durationAsString := strings.TrimSpace(response)
 
 	return strconv.Atoi(durationAsString)
 }
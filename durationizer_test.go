@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+		ok    bool
+	}{
+		{"90 minutes on the IZG CC tickets", 90 * time.Minute, true},
+		{"1.5 hrs of code review", 90 * time.Minute, true},
+		{"half an hour with Bob", 30 * time.Minute, true},
+		{"an hour and a half planning", 90 * time.Minute, true},
+		{"a quarter of an hour", 15 * time.Minute, true},
+		{"a couple of hours on the release", 2 * time.Hour, true},
+		{"two hours debugging", 2 * time.Hour, true},
+		{"forty five minutes standup", 45 * time.Minute, true},
+		{"2 and a half hours", 150 * time.Minute, true},
+		{"2h15 on FEDS-148", 135 * time.Minute, true},
+		{"1h 15m", 75 * time.Minute, true},
+		{"2h15m", 135 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"3h45m migrating data", 225 * time.Minute, true},
+		{"1hr30min", 90 * time.Minute, true},
+		{"2 hours 15 minutes", 135 * time.Minute, true},
+		{"45m", 45 * time.Minute, true},
+		{"20 mins", 20 * time.Minute, true},
+		{"3 hours", 3 * time.Hour, true},
+		{"9:30-11:00 sprint planning", 90 * time.Minute, true},
+		{"yesterday 2-4pm worked on IZG CC", 2 * time.Hour, true},
+		{"from 9 to 10:30", 90 * time.Minute, true},
+		{"11-1pm workshop", 2 * time.Hour, true},
+		{"2025-05-13 9:30-11:00 IZG work", 90 * time.Minute, true},
+		{"1,5 hours", 90 * time.Minute, true},
+		{"version 2.5m release notes", 0, false},
+		{"worked on the release", 0, false},
+		{"30 hours", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, ok := parseDuration(test.input)
+			if ok != test.ok || got != test.want {
+				t.Errorf("parseDuration(%q) = %v, %t, want %v, %t", test.input, got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestParseWorkTime(t *testing.T) {
+	// A Wednesday afternoon
+	now := time.Date(2025, 5, 14, 16, 20, 0, 0, time.UTC)
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2025, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		input     string
+		duration  time.Duration
+		wantStart time.Time
+		wantEnd   time.Time
+		ok        bool
+	}{
+		{"yesterday 2-4pm worked on IZG CC", 2 * time.Hour, at(13, 14, 0), at(13, 16, 0), true},
+		{"9:30-11:00 sprint planning", 90 * time.Minute, at(14, 9, 30), at(14, 11, 0), true},
+		{"this morning for an hour", time.Hour, at(14, 9, 0), at(14, 10, 0), true},
+		{"last friday 2h on the report", 2 * time.Hour, at(9, 14, 20), at(9, 16, 20), true},
+		{"the day before yesterday at 2pm for 30m", 30 * time.Minute, at(12, 14, 0), at(12, 14, 30), true},
+		{"2025-05-13 9:30-11:00 IZG work", 90 * time.Minute, at(13, 9, 30), at(13, 11, 0), true},
+		{"2025-05-01 afternoon 1h", time.Hour, at(1, 13, 0), at(1, 14, 0), true},
+		{"on wednesday 45m", 45 * time.Minute, at(14, 15, 35), at(14, 16, 20), true},
+		{"worked on the release 1h", time.Hour, time.Time{}, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			start, end, ok := parseWorkTime(test.input, test.duration, now)
+			if ok != test.ok || !start.Equal(test.wantStart) || !end.Equal(test.wantEnd) {
+				t.Errorf("parseWorkTime(%q) = %v, %v, %t, want %v, %v, %t", test.input, start, end, ok, test.wantStart, test.wantEnd, test.ok)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{75 * time.Minute, "1h 15m"},
+		{2 * time.Hour, "2h"},
+		{30 * time.Minute, "30m"},
+	}
+
+	for _, test := range tests {
+		if got := formatDuration(test.duration); got != test.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", test.duration, got, test.want)
+		}
+	}
+}
+
+func TestProcessActivityRecordsDurationPromptVersion(t *testing.T) {
+	defer func(client LLMClient, chain categorizerChain) { llmClient, categorizer = client, chain }(llmClient, categorizer)
+	llmClient = newFakeLLMClient("1h 30m")
+	categorizer = categorizerChain{}
+
+	_, wantVersion, err := renderPrompt("duration.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input       string
+		wantSource  string
+		wantVersion string
+	}{
+		{"all morning on the release", durationSourceLLM, wantVersion},
+		{"2h on the release", durationSourceParsed, ""},
+		{"worked on the release", durationSourceDefault, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			activity := processActivity(Activity{InputDescription: test.input, CreatedAt: time.Now()})
+			if activity.DurationSource != test.wantSource || activity.DurationPromptVersion != test.wantVersion {
+				t.Errorf("duration source %q prompt version %q, want %q and %q", activity.DurationSource, activity.DurationPromptVersion, test.wantSource, test.wantVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Grades in the order they're reported, N/A is no candidate at all
+var evaluationGrades = []string{"A", "B", "C", "D", "F", "N/A"}
+
+// EvaluationCase is one labelled row, empty expected fields aren't checked
+type EvaluationCase struct {
+	Description string `json:"description"`
+	Project     string `json:"project"`
+	Task        string `json:"task"`
+	Jira        string `json:"jira"`
+}
+
+// EvaluationItem is how one case came out
+type EvaluationItem struct {
+	EvaluationCase
+	PredictedProject string  `json:"predicted_project"`
+	PredictedTask    string  `json:"predicted_task"`
+	PredictedJira    string  `json:"predicted_jira"`
+	Grade            string  `json:"grade"`
+	Distance         float64 `json:"distance"`
+	AutoApplied      bool    `json:"auto_applied"`
+	// 1 based position of the first correct candidate, 0 if none was
+	CorrectRank   int      `json:"correct_rank"`
+	CategorizedBy string   `json:"categorized_by"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// EvaluationProject is the confusion summary for one expected project,
+// Predicted counts what the closest candidate's project was
+type EvaluationProject struct {
+	Project   string         `json:"project"`
+	Total     int            `json:"total"`
+	Correct   int            `json:"correct"`
+	Accuracy  float64        `json:"accuracy"`
+	Predicted map[string]int `json:"predicted"`
+}
+
+// EvaluationGrade is one row of the calibration table, how often the closest
+// candidate was right when it got this grade
+type EvaluationGrade struct {
+	Grade        string  `json:"grade"`
+	Count        int     `json:"count"`
+	Correct      int     `json:"correct"`
+	Accuracy     float64 `json:"accuracy"`
+	MeanDistance float64 `json:"mean_distance"`
+	AutoApplied  int     `json:"auto_applied"`
+}
+
+// EvaluationSettings is what the run was done with, to tell runs apart
+type EvaluationSettings struct {
+	Categorizer     string                   `json:"categorizer"`
+	WeaviateClass   string                   `json:"weaviate_class"`
+	EmbedModel      string                   `json:"embed_model"`
+	LocalEmbedModel string                   `json:"local_embed_model,omitempty"`
+	GradeThresholds []GradeThreshold         `json:"grade_thresholds"`
+	AutoGrades      []string                 `json:"auto_grades"`
+	AutoOverrides   []AutoCategorizeOverride `json:"auto_overrides,omitempty"`
+}
+
+type EvaluationResult struct {
+	Name         string             `json:"name"`
+	Input        string             `json:"input"`
+	RanAt        time.Time          `json:"ran_at"`
+	Settings     EvaluationSettings `json:"settings"`
+	Total        int                `json:"total"`
+	Top1Correct  int                `json:"top1_correct"`
+	Top3Correct  int                `json:"top3_correct"`
+	Top1Accuracy float64            `json:"top1_accuracy"`
+	Top3Accuracy float64            `json:"top3_accuracy"`
+	AutoApplied  int                `json:"auto_applied"`
+	AutoCorrect  int                `json:"auto_correct"`
+	// Of the ones that would have been categorized automatically, how many were right
+	AutoPrecision float64             `json:"auto_precision"`
+	Errors        int                 `json:"errors"`
+	Projects      []EvaluationProject `json:"projects"`
+	Grades        []EvaluationGrade   `json:"grades"`
+	Items         []EvaluationItem    `json:"items"`
+}
+
+// runEvaluation is the evaluate command,
+//
+//	go run . evaluate -input labelled.csv [-name baseline] [-output evaluations] [-compare evaluations/earlier.json]
+//
+// Every description in the CSV (description,project,task,jira with a header)
+// goes through the configured categorizer chain. The report is printed and
+// saved as JSON so later runs, with other rules, embedding models or grade
+// thresholds, can be compared against it.
+func runEvaluation(args []string) error {
+	flags := flag.NewFlagSet("evaluate", flag.ContinueOnError)
+	input := flags.String("input", "", "labelled CSV with description,project,task,jira columns")
+	name := flags.String("name", "", "label for this run, saved in the file name")
+	output := flags.String("output", "evaluations", "directory the results are saved in")
+	compare := flags.String("compare", "", "earlier results JSON to compare this run with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return errors.New("-input is required")
+	}
+
+	cases, err := readEvaluationCases(*input)
+	if err != nil {
+		return err
+	}
+	log.Printf("evaluation - %d labelled descriptions from '%s'", len(cases), *input)
+
+	result := evaluateCases(context.Background(), cases)
+	result.Name = *name
+	result.Input = *input
+
+	printEvaluation(os.Stdout, result)
+
+	if *compare != "" {
+		previous, err := readEvaluationResult(*compare)
+		if err != nil {
+			return err
+		}
+		printEvaluationComparison(os.Stdout, previous, result)
+	}
+
+	file, err := saveEvaluationResult(*output, result)
+	if err != nil {
+		return err
+	}
+	log.Printf("evaluation - results saved to '%s'", file)
+
+	return nil
+}
+
+func readEvaluationCases(file string) ([]EvaluationCase, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening '%s': %w", file, err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header of '%s': %w", file, err)
+	}
+
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, found := columns["description"]; !found {
+		return nil, fmt.Errorf("'%s' needs a description column", file)
+	}
+
+	field := func(record []string, column string) string {
+		i, found := columns[column]
+		if !found || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var cases []EvaluationCase
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s': %w", file, err)
+		}
+
+		evaluationCase := EvaluationCase{
+			Description: field(record, "description"),
+			Project:     field(record, "project"),
+			Task:        field(record, "task"),
+			Jira:        field(record, "jira"),
+		}
+		if evaluationCase.Description == "" {
+			continue
+		}
+		if evaluationCase.Project == "" && evaluationCase.Task == "" && evaluationCase.Jira == "" {
+			log.Printf("evaluation - skipping '%s', nothing expected", evaluationCase.Description)
+			continue
+		}
+		cases = append(cases, evaluationCase)
+	}
+
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no labelled descriptions in '%s'", file)
+	}
+
+	return cases, nil
+}
+
+// A candidate is right if it agrees with everything the case expects
+func evaluationMatch(expected EvaluationCase, candidate CandidateRule) bool {
+	return (expected.Project == "" || strings.EqualFold(expected.Project, candidate.Project)) &&
+		(expected.Task == "" || strings.EqualFold(expected.Task, candidate.Task)) &&
+		(expected.Jira == "" || strings.EqualFold(expected.Jira, candidate.Jira))
+}
+
+// evaluateCases categorizes every case the way categorizeActivity would and
+// tallies the results
+func evaluateCases(ctx context.Context, cases []EvaluationCase) EvaluationResult {
+	result := EvaluationResult{
+		RanAt: time.Now(),
+		Settings: EvaluationSettings{
+			Categorizer:     categorizer.Name(),
+			WeaviateClass:   weaviateClass,
+			EmbedModel:      weaviateEmbedModel,
+			GradeThresholds: gradeThresholds,
+			AutoGrades:      autoGrades,
+			AutoOverrides:   autoGradeOverrides,
+		},
+		Total: len(cases),
+	}
+	if categorizer.uses("local") && localEmbedEndpoint != "" {
+		result.Settings.LocalEmbedModel = localEmbedModel
+	}
+
+	projects := make(map[string]*EvaluationProject)
+	grades := make(map[string]*EvaluationGrade)
+	for _, grade := range evaluationGrades {
+		grades[grade] = &EvaluationGrade{Grade: grade}
+	}
+
+	for i, evaluationCase := range cases {
+		categorization := categorizer.categorize(ctx, evaluationCase.Description)
+
+		item := EvaluationItem{
+			EvaluationCase: evaluationCase,
+			Grade:          "N/A",
+			CategorizedBy:  categorization.Stage,
+			Errors:         categorization.Errors,
+		}
+		if len(item.Errors) > 0 {
+			result.Errors++
+		}
+
+		for rank, candidate := range categorization.Candidates {
+			if evaluationMatch(evaluationCase, candidate) {
+				item.CorrectRank = rank + 1
+				break
+			}
+		}
+
+		if len(categorization.Candidates) > 0 {
+			closest := categorization.Candidates[0]
+			item.PredictedProject = closest.Project
+			item.PredictedTask = closest.Task
+			item.PredictedJira = closest.Jira
+			item.Grade = closest.Grade
+			item.Distance = closest.Distance
+			item.AutoApplied = slices.Contains(autoCategorizeGrades(closest.Project, closest.Jira), closest.Grade)
+		}
+
+		top1 := item.CorrectRank == 1
+		if top1 {
+			result.Top1Correct++
+		}
+		if item.CorrectRank >= 1 && item.CorrectRank <= 3 {
+			result.Top3Correct++
+		}
+		if item.AutoApplied {
+			result.AutoApplied++
+			if top1 {
+				result.AutoCorrect++
+			}
+		}
+
+		projectName := cmp.Or(evaluationCase.Project, "(none)")
+		project, found := projects[projectName]
+		if !found {
+			project = &EvaluationProject{Project: projectName, Predicted: make(map[string]int)}
+			projects[projectName] = project
+		}
+		project.Total++
+		if top1 {
+			project.Correct++
+		}
+		project.Predicted[cmp.Or(item.PredictedProject, "(none)")]++
+
+		grade, found := grades[item.Grade]
+		if !found {
+			grade = &EvaluationGrade{Grade: item.Grade}
+			grades[item.Grade] = grade
+		}
+		grade.Count++
+		grade.MeanDistance += item.Distance
+		if top1 {
+			grade.Correct++
+		}
+		if item.AutoApplied {
+			grade.AutoApplied++
+		}
+
+		result.Items = append(result.Items, item)
+
+		if (i+1)%25 == 0 {
+			log.Printf("evaluation - %d of %d done", i+1, len(cases))
+		}
+	}
+
+	result.Top1Accuracy = ratio(result.Top1Correct, result.Total)
+	result.Top3Accuracy = ratio(result.Top3Correct, result.Total)
+	result.AutoPrecision = ratio(result.AutoCorrect, result.AutoApplied)
+
+	for _, project := range projects {
+		project.Accuracy = ratio(project.Correct, project.Total)
+		result.Projects = append(result.Projects, *project)
+	}
+	slices.SortFunc(result.Projects, func(a, b EvaluationProject) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), cmp.Compare(a.Project, b.Project))
+	})
+
+	for _, grade := range grades {
+		if grade.Count > 0 {
+			grade.MeanDistance /= float64(grade.Count)
+		}
+		grade.Accuracy = ratio(grade.Correct, grade.Count)
+	}
+	for _, name := range evaluationGrades {
+		result.Grades = append(result.Grades, *grades[name])
+		delete(grades, name)
+	}
+	// Any grade configured beyond the usual ones goes at the end
+	for _, name := range slices.Sorted(maps.Keys(grades)) {
+		result.Grades = append(result.Grades, *grades[name])
+	}
+
+	return result
+}
+
+func ratio(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
+}
+
+func printEvaluation(w io.Writer, result EvaluationResult) {
+	fmt.Fprintf(w, "\nEvaluation of %d descriptions with %s\n", result.Total, result.Settings.Categorizer)
+	fmt.Fprintf(w, "  top-1 accuracy:  %.1f%% (%d)\n", result.Top1Accuracy*100, result.Top1Correct)
+	fmt.Fprintf(w, "  top-3 accuracy:  %.1f%% (%d)\n", result.Top3Accuracy*100, result.Top3Correct)
+	fmt.Fprintf(w, "  auto applied:    %d, %.1f%% of them correct\n", result.AutoApplied, result.AutoPrecision*100)
+	if result.Errors > 0 {
+		fmt.Fprintf(w, "  with errors:     %d\n", result.Errors)
+	}
+
+	fmt.Fprintf(w, "\nBy expected project\n")
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  PROJECT\tTOTAL\tCORRECT\tACCURACY\tPREDICTED AS")
+	for _, project := range result.Projects {
+		fmt.Fprintf(table, "  %s\t%d\t%d\t%.1f%%\t%s\n", project.Project, project.Total, project.Correct, project.Accuracy*100, formatPredicted(project.Predicted))
+	}
+	table.Flush()
+
+	fmt.Fprintf(w, "\nGrade calibration\n")
+	table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  GRADE\tCOUNT\tCORRECT\tACCURACY\tMEAN DISTANCE\tAUTO APPLIED")
+	for _, grade := range result.Grades {
+		if grade.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(table, "  %s\t%d\t%d\t%.1f%%\t%.3f\t%d\n", grade.Grade, grade.Count, grade.Correct, grade.Accuracy*100, grade.MeanDistance, grade.AutoApplied)
+	}
+	table.Flush()
+}
+
+// Most common first, e.g. "Billing 12, Ops 3"
+func formatPredicted(predicted map[string]int) string {
+	projects := slices.Collect(maps.Keys(predicted))
+	slices.SortFunc(projects, func(a, b string) int {
+		return cmp.Or(cmp.Compare(predicted[b], predicted[a]), cmp.Compare(a, b))
+	})
+
+	parts := make([]string, 0, len(projects))
+	for _, project := range projects {
+		parts = append(parts, fmt.Sprintf("%s %d", project, predicted[project]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printEvaluationComparison shows what changed since an earlier run. Cases are
+// matched on description so it's only meaningful against the same dataset.
+func printEvaluationComparison(w io.Writer, previous EvaluationResult, current EvaluationResult) {
+	fmt.Fprintf(w, "\nCompared with %s (%s, %s)\n", cmp.Or(previous.Name, "the earlier run"), previous.RanAt.Format(time.DateTime), previous.Settings.Categorizer)
+	fmt.Fprintf(w, "  top-1 accuracy:  %.1f%% -> %.1f%% (%+.1f)\n", previous.Top1Accuracy*100, current.Top1Accuracy*100, (current.Top1Accuracy-previous.Top1Accuracy)*100)
+	fmt.Fprintf(w, "  top-3 accuracy:  %.1f%% -> %.1f%% (%+.1f)\n", previous.Top3Accuracy*100, current.Top3Accuracy*100, (current.Top3Accuracy-previous.Top3Accuracy)*100)
+	fmt.Fprintf(w, "  auto precision:  %.1f%% -> %.1f%% (%+.1f)\n", previous.AutoPrecision*100, current.AutoPrecision*100, (current.AutoPrecision-previous.AutoPrecision)*100)
+	fmt.Fprintf(w, "  auto applied:    %d -> %d\n", previous.AutoApplied, current.AutoApplied)
+
+	before := make(map[string]EvaluationItem, len(previous.Items))
+	for _, item := range previous.Items {
+		before[item.Description] = item
+	}
+
+	var fixed, broken []string
+	for _, item := range current.Items {
+		earlier, found := before[item.Description]
+		if !found {
+			continue
+		}
+		switch {
+		case earlier.CorrectRank != 1 && item.CorrectRank == 1:
+			fixed = append(fixed, item.Description)
+		case earlier.CorrectRank == 1 && item.CorrectRank != 1:
+			broken = append(broken, item.Description)
+		}
+	}
+
+	fmt.Fprintf(w, "  now correct:     %d\n", len(fixed))
+	for _, description := range fixed {
+		fmt.Fprintf(w, "    + %s\n", description)
+	}
+	fmt.Fprintf(w, "  now wrong:       %d\n", len(broken))
+	for _, description := range broken {
+		fmt.Fprintf(w, "    - %s\n", description)
+	}
+}
+
+func readEvaluationResult(file string) (EvaluationResult, error) {
+	var result EvaluationResult
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return result, fmt.Errorf("error reading '%s': %w", file, err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("error parsing '%s': %w", file, err)
+	}
+
+	return result, nil
+}
+
+// Saved as {dir}/{time}[-{name}].json so they list in the order they ran
+func saveEvaluationResult(dir string, result EvaluationResult) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating '%s': %w", dir, err)
+	}
+
+	filename := result.RanAt.Format("20060102-150405")
+	if result.Name != "" {
+		filename += "-" + strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == ' ' {
+				return '_'
+			}
+			return r
+		}, result.Name)
+	}
+	file := filepath.Join(dir, filename+".json")
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return file, os.WriteFile(file, data, 0644)
+}
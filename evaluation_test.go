@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// evaluationCategorizer answers with the candidates listed for each description
+type evaluationCategorizer map[string][]CandidateRule
+
+func (e evaluationCategorizer) Name() string {
+	return "evaluation"
+}
+
+func (e evaluationCategorizer) FindCandidates(ctx context.Context, description string) ([]CandidateRule, error) {
+	return e[description], nil
+}
+
+func (e evaluationCategorizer) Categorize(ctx context.Context, description string) ([]CandidateRule, string, error) {
+	return e[description], "", nil
+}
+
+func TestEvaluateCases(t *testing.T) {
+	candidate := func(jira string, grade string, distance float64) CandidateRule {
+		return CandidateRule{Project: jira[:3], Jira: jira, Grade: grade, Distance: distance}
+	}
+
+	defer func(chain categorizerChain, grades []string, overrides []AutoCategorizeOverride) {
+		categorizer, autoGrades, autoGradeOverrides = chain, grades, overrides
+	}(categorizer, autoGrades, autoGradeOverrides)
+	autoGrades, autoGradeOverrides = []string{"A"}, nil
+	categorizer = categorizerChain{evaluationCategorizer{
+		"release notes": {candidate("FED-148", "A", 0.05), candidate("IZG-7", "B", 0.2)},
+		"docs":          {candidate("FED-148", "B", 0.2), candidate("IZG-7", "C", 0.3)},
+		"standup":       {candidate("FED-148", "C", 0.3), candidate("IZG-7", "C", 0.32), candidate("ABC-1", "D", 0.4), candidate("OPS-1", "D", 0.45)},
+	}}
+
+	result := evaluateCases(context.Background(), []EvaluationCase{
+		{Description: "release notes", Jira: "FED-148"},
+		{Description: "docs", Project: "IZG", Jira: "IZG-7"},
+		{Description: "standup", Jira: "OPS-1"},
+		{Description: "lunch", Jira: "OPS-1"},
+	})
+
+	if result.Total != 4 || result.Top1Correct != 1 || result.Top3Correct != 2 {
+		t.Errorf("got %d total, %d top 1, %d top 3, want 4, 1 and 2", result.Total, result.Top1Correct, result.Top3Correct)
+	}
+	if result.Top1Accuracy != 0.25 || result.Top3Accuracy != 0.5 {
+		t.Errorf("got top 1 accuracy %f and top 3 %f, want 0.25 and 0.5", result.Top1Accuracy, result.Top3Accuracy)
+	}
+	if result.AutoApplied != 1 || result.AutoCorrect != 1 || result.AutoPrecision != 1 {
+		t.Errorf("got %d auto applied, %d correct, want 1 and 1", result.AutoApplied, result.AutoCorrect)
+	}
+
+	wantGrades := map[string][2]int{"A": {1, 1}, "B": {1, 0}, "C": {1, 0}, "D": {0, 0}, "F": {0, 0}, "N/A": {1, 0}}
+	if len(result.Grades) != len(wantGrades) {
+		t.Fatalf("got %d grades, want %d", len(result.Grades), len(wantGrades))
+	}
+	for i, grade := range result.Grades {
+		if grade.Grade != evaluationGrades[i] {
+			t.Errorf("grade %d is %s, want %s", i, grade.Grade, evaluationGrades[i])
+		}
+		if want := wantGrades[grade.Grade]; grade.Count != want[0] || grade.Correct != want[1] {
+			t.Errorf("grade %s has %d with %d correct, want %d with %d", grade.Grade, grade.Count, grade.Correct, want[0], want[1])
+		}
+	}
+
+	if item := result.Items[2]; item.CorrectRank != 4 || item.PredictedJira != "FED-148" {
+		t.Errorf("standup came out %+v, want FED-148 predicted and OPS-1 4th", item)
+	}
+}
+
+func TestReadEvaluationCases(t *testing.T) {
+	tests := []struct {
+		name      string
+		csv       string
+		wantCases int
+		wantError bool
+	}{
+		{"labelled", "Description,Project,Task,Jira\nrelease notes,FEDS,Release,FEDS-148\ndocs,IZG,,\n,FEDS,,\nlunch,,,\n", 2, false},
+		{"only a description and jira", "jira,description\nFEDS-148,release notes\n", 1, false},
+		{"no description column", "summary,jira\nrelease notes,FEDS-148\n", 0, true},
+		{"nothing labelled", "description,project\nlunch,\n", 0, true},
+		{"empty", "", 0, true},
+		{"bad quoting", "description,jira\n\"release notes,FEDS-148\n", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "labelled.csv")
+			if err := os.WriteFile(file, []byte(test.csv), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cases, err := readEvaluationCases(file)
+			if (err != nil) != test.wantError || len(cases) != test.wantCases {
+				t.Errorf("readEvaluationCases() = %d cases, error %v, want %d cases, error %t", len(cases), err, test.wantCases, test.wantError)
+			}
+		})
+	}
+
+	if _, err := readEvaluationCases(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("readEvaluationCases() of a missing file didn't return an error")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types sent on /api/v1/events
+const (
+	eventActivityCreated     = "activity.created"
+	eventActivityCategorized = "activity.categorized"
+	eventActivityUpdated     = "activity.updated"
+	eventActivityDeleted     = "activity.deleted"
+	eventActivityPosted      = "activity.posted"
+	eventRuleChanged         = "rule.changed"
+	eventRuleDeleted         = "rule.deleted"
+)
+
+// How many events a slow subscriber can fall behind before it misses some
+const eventBufferSize = 64
+
+// Comment lines keep idle connections (and any proxies) from timing out
+const eventKeepAlive = 30 * time.Second
+
+// Event is one update, Data is the activity (or rules) as they are now
+type Event struct {
+	Id   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+type eventHub struct {
+	mu          sync.Mutex
+	lastId      uint64
+	subscribers map[chan Event]bool
+}
+
+var events = &eventHub{subscribers: make(map[chan Event]bool)}
+
+// publish hands the event to every subscriber without waiting on any of them
+func (h *eventHub) publish(eventType string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastId++
+	event := Event{Id: h.lastId, Type: eventType, Time: time.Now(), Data: data}
+
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Printf("events - subscriber is behind, dropped event %d (%s)", event.Id, event.Type)
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subscriber := make(chan Event, eventBufferSize)
+	h.subscribers[subscriber] = true
+	return subscriber
+}
+
+func (h *eventHub) unsubscribe(subscriber chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, subscriber)
+}
+
+func publishActivity(eventType string, activity Activity) {
+	events.publish(eventType, activity)
+}
+
+type EventManager struct{}
+
+// ServeHTTP streams events as server-sent events until the client goes away.
+// ?types=activity.posted,rule.changed only sends those types.
+func (h *EventManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var types []string
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = strings.Split(value, ",")
+	}
+
+	log.Printf("events - subscriber connected (types: %s)", r.URL.Query().Get("types"))
+
+	subscriber := events.subscribe()
+	defer events.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Println("events - subscriber disconnected")
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-subscriber:
+			if types != nil && !slices.Contains(types, event.Type) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("events - error encoding event %d: %v", event.Id, err)
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Which issue field worklogs are sent with, JIRA_TEMPO_ISSUE_FIELD
+const (
+	// Tempo Cloud's v4 API only takes the numeric issueId
+	tempoIssueById = "id"
+	// Tempo Server and the older Cloud APIs take the issueKey
+	tempoIssueByKey = "key"
+)
+
+// JiraLookupError is returned when an issue id couldn't be read from Jira.
+// StatusCode is 0 when Jira couldn't be reached at all.
+type JiraLookupError struct {
+	Key        string
+	StatusCode int
+	Err        error
+}
+
+func (e *JiraLookupError) Error() string {
+	return fmt.Sprintf("error looking up Jira issue '%s': %v", e.Key, e.Err)
+}
+
+func (e *JiraLookupError) Unwrap() error {
+	return e.Err
+}
+
+// Whether trying the lookup again later might work
+func (e *JiraLookupError) Temporary() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+var errJiraNotConfigured = errors.New("JIRA_BASE_URL isn't set, set it or JIRA_TEMPO_ISSUE_FIELD=key to send the issue key instead")
+
+// jiraIssueResolver turns issue keys like FEDS-148 into Jira's numeric ids.
+// Ids never change so each key is only looked up once.
+type jiraIssueResolver struct {
+	baseURL string
+	email   string
+	token   string
+	client  *http.Client
+
+	mu  sync.Mutex
+	ids map[string]int
+}
+
+var jiraIssues *jiraIssueResolver
+
+// With an email the token is a Jira Cloud API token (basic auth), without one
+// it's sent as a bearer token like a Jira Server personal access token
+func newJiraIssueResolver(baseURL string, email string, token string) *jiraIssueResolver {
+	return &jiraIssueResolver{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		email:   email,
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		ids:     make(map[string]int),
+	}
+}
+
+func (j *jiraIssueResolver) issueId(ctx context.Context, key string) (int, error) {
+	key = strings.ToUpper(strings.TrimSpace(key))
+
+	j.mu.Lock()
+	id, found := j.ids[key]
+	j.mu.Unlock()
+	if found {
+		return id, nil
+	}
+
+	if j.baseURL == "" {
+		return 0, &JiraLookupError{Key: key, StatusCode: http.StatusBadRequest, Err: errJiraNotConfigured}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, j.baseURL+"/rest/api/3/issue/"+url.PathEscape(key)+"?fields=id", nil)
+	if err != nil {
+		return 0, &JiraLookupError{Key: key, StatusCode: http.StatusBadRequest, Err: err}
+	}
+	request.Header.Set("Accept", "application/json")
+	if j.email != "" {
+		request.SetBasicAuth(j.email, j.token)
+	} else if j.token != "" {
+		request.Header.Set("Authorization", "Bearer "+j.token)
+	}
+
+	response, err := j.client.Do(request)
+	if err != nil {
+		return 0, &JiraLookupError{Key: key, Err: err}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return 0, &JiraLookupError{Key: key, StatusCode: response.StatusCode, Err: fmt.Errorf("Jira returned %s: %s", response.Status, strings.TrimSpace(string(body)))}
+	}
+
+	var issue struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&issue); err != nil {
+		return 0, &JiraLookupError{Key: key, StatusCode: response.StatusCode, Err: fmt.Errorf("error parsing Jira response: %w", err)}
+	}
+
+	id, err = strconv.Atoi(issue.Id)
+	if err != nil {
+		return 0, &JiraLookupError{Key: key, StatusCode: response.StatusCode, Err: fmt.Errorf("Jira issue id '%s' isn't a number", issue.Id)}
+	}
+
+	j.mu.Lock()
+	j.ids[key] = id
+	j.mu.Unlock()
+
+	return id, nil
+}
+
+// A failed issue lookup that may work if the push is tried again later
+func temporaryJiraError(err error) bool {
+	var lookupError *JiraLookupError
+	return errors.As(err, &lookupError) && lookupError.Temporary()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewTempoWorklogIssue(t *testing.T) {
+	lookups := 0
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if email, token, ok := r.BasicAuth(); !ok || email != "me@example.com" || token != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/rest/api/3/issue/FEDS-148":
+			lookups++
+			w.Write([]byte(`{"id": "10042", "key": "FEDS-148"}`))
+		case "/rest/api/3/issue/FEDS-503":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, `{"errorMessages": ["Issue does not exist"]}`, http.StatusNotFound)
+		}
+	}))
+	defer jira.Close()
+
+	defer func(field string, resolver *jiraIssueResolver) { tempoIssueField, jiraIssues = field, resolver }(tempoIssueField, jiraIssues)
+	jiraIssues = newJiraIssueResolver(jira.URL+"/", "me@example.com", "secret")
+
+	tests := []struct {
+		name      string
+		field     string
+		jira      string
+		wantId    int
+		wantKey   string
+		wantError bool
+		temporary bool
+	}{
+		{"key looked up for v4", tempoIssueById, "FEDS-148", 10042, "", false, false},
+		{"lower case key", tempoIssueById, "feds-148", 10042, "", false, false},
+		{"numeric jira is the id", tempoIssueById, "10099", 10099, "", false, false},
+		{"missing issue", tempoIssueById, "NOPE-1", 0, "", true, false},
+		{"jira down", tempoIssueById, "FEDS-503", 0, "", true, true},
+		{"key sent as is", tempoIssueByKey, "FEDS-148", 0, "FEDS-148", false, false},
+		{"numeric jira with keys", tempoIssueByKey, "10099", 10099, "", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tempoIssueField = test.field
+			activity := Activity{ActivityId: "a1", Jira: test.jira, TimeSpent: time.Hour, CreatedAt: time.Now()}
+
+			worklog, err := newTempoWorklog(context.Background(), activity)
+			if (err != nil) != test.wantError {
+				t.Fatalf("newTempoWorklog() error = %v, want error %t", err, test.wantError)
+			}
+			if err != nil {
+				if temporaryJiraError(err) != test.temporary {
+					t.Errorf("temporaryJiraError(%v) = %t, want %t", err, !test.temporary, test.temporary)
+				}
+				return
+			}
+			if worklog.IssueId != test.wantId || worklog.IssueKey != test.wantKey {
+				t.Errorf("issue id %d key %q, want id %d key %q", worklog.IssueId, worklog.IssueKey, test.wantId, test.wantKey)
+			}
+		})
+	}
+
+	if lookups != 1 {
+		t.Errorf("Jira was asked for FEDS-148 %d times, want once", lookups)
+	}
+}
+
+func TestJiraIssueResolverNotConfigured(t *testing.T) {
+	_, err := newJiraIssueResolver("", "", "").issueId(context.Background(), "FEDS-148")
+	if err == nil || temporaryJiraError(err) {
+		t.Errorf("issueId() without JIRA_BASE_URL = %v, want a permanent error", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LLMClient is how the tracker asks a language model something, at the moment
+// only the durationizer fallbacks. LLM_PROVIDER picks the implementation so
+// Ollama, anything OpenAI compatible (llama.cpp, vLLM, ...) or the fake can
+// be used without touching the callers.
+type LLMClient interface {
+	// Generate returns the model's answer to prompt, following the system prompt
+	Generate(ctx context.Context, request LLMRequest) (string, error)
+}
+
+type LLMRequest struct {
+	System string
+	Prompt string
+}
+
+// LLMOptions are shared by every provider, see the LLM_* settings in main.go
+type LLMOptions struct {
+	Endpoint    string
+	Model       string
+	APIKey      string
+	Timeout     time.Duration
+	Retries     int
+	Temperature float64
+	MaxTokens   int
+	// Ask for a JSON object back, the answer is unwrapped before it's returned
+	JSONMode bool
+}
+
+var llmClient LLMClient
+
+func newLLMClient(provider string, options LLMOptions) (LLMClient, error) {
+	var client LLMClient
+	switch provider {
+	case "", "ollama":
+		client = newOllamaLLMClient(options)
+	case "openai":
+		client = newOpenAILLMClient(options)
+	case "fake":
+		// Never fails or retries so no need to wrap it
+		return newFakeLLMClient(llmFakeResponse), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider '%s', expected ollama, openai or fake", provider)
+	}
+
+	if options.JSONMode {
+		client = &jsonLLMClient{client: client}
+	}
+
+	return &retryingLLMClient{client: client, retries: options.Retries}, nil
+}
+
+// llmStatusError is a non 2xx response from an LLM server
+type llmStatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *llmStatusError) Error() string {
+	return fmt.Sprintf("LLM API returned error: %s - %s", e.Status, e.Body)
+}
+
+// A 4xx (other than rate limiting) means the request itself is wrong, retrying won't help
+func retryableLLMError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusError *llmStatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode >= 500 || statusError.StatusCode == http.StatusTooManyRequests
+	}
+
+	// Timeouts, refused connections and the like
+	return true
+}
+
+type retryingLLMClient struct {
+	client  LLMClient
+	retries int
+}
+
+func (c *retryingLLMClient) Generate(ctx context.Context, request LLMRequest) (string, error) {
+	delay := 500 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		response, err := c.client.Generate(ctx, request)
+		if err == nil || attempt >= c.retries || !retryableLLMError(err) {
+			return response, err
+		}
+
+		log.Printf("\tLLM request failed, retrying in %s: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// jsonLLMClient asks for {"answer": "..."} and hands back just the answer so
+// callers don't care whether JSON mode is on
+type jsonLLMClient struct {
+	client LLMClient
+}
+
+const llmJSONInstruction = `
+
+Respond with a JSON object of the form {"answer": "<your answer>"} and nothing else.`
+
+func (c *jsonLLMClient) Generate(ctx context.Context, request LLMRequest) (string, error) {
+	request.System += llmJSONInstruction
+
+	response, err := c.client.Generate(ctx, request)
+	if err != nil {
+		return "", err
+	}
+
+	var answer struct {
+		Answer json.RawMessage `json:"answer"`
+	}
+	if err := json.Unmarshal([]byte(response), &answer); err != nil || answer.Answer == nil {
+		return "", fmt.Errorf("LLM response '%s' isn't the expected JSON", strings.TrimSpace(response))
+	}
+
+	// Models sometimes answer with a number rather than a string
+	var text string
+	if err := json.Unmarshal(answer.Answer, &text); err != nil {
+		text = string(answer.Answer)
+	}
+
+	return text, nil
+}
+
+// Ask the configured model, for callers that don't have a request context
+func generateWithLLM(system string, prompt string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), llmOptions.Timeout*time.Duration(llmOptions.Retries+1))
+	defer cancel()
+
+	return llmClient.Generate(ctx, LLMRequest{System: system, Prompt: prompt})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+var errNoFakeResponse = errors.New("fake LLM has no response for this prompt")
+
+// Only the latest requests are kept, LLM_PROVIDER=fake can run in a server for days
+const maxFakeLLMRequests = 100
+
+// fakeLLMClient answers from a fixed table so nothing needs a live model.
+// A prompt without an entry gets Default, or errNoFakeResponse if that's empty.
+// The last maxFakeLLMRequests requests are kept so a test can check what was asked.
+type fakeLLMClient struct {
+	mu        sync.Mutex
+	Responses map[string]string
+	Default   string
+	Requests  []LLMRequest
+}
+
+func newFakeLLMClient(defaultResponse string) *fakeLLMClient {
+	return &fakeLLMClient{
+		Responses: make(map[string]string),
+		Default:   defaultResponse,
+	}
+}
+
+func (c *fakeLLMClient) Generate(ctx context.Context, request LLMRequest) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Requests = append(c.Requests, request)
+	if len(c.Requests) > maxFakeLLMRequests {
+		c.Requests = slices.Delete(c.Requests, 0, len(c.Requests)-maxFakeLLMRequests)
+	}
+
+	if response, found := c.Responses[request.Prompt]; found {
+		return response, nil
+	}
+	if c.Default != "" {
+		return c.Default, nil
+	}
+	return "", errNoFakeResponse
+}
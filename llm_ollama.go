@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ollamaLLMClient uses Ollama's native /api/generate endpoint, LLM_ENDPOINT
+// is the full URL e.g. http://localhost:11434/api/generate
+type ollamaLLMClient struct {
+	options    LLMOptions
+	httpClient *http.Client
+}
+
+type ollamaGenerateRequest struct {
+	Model   string        `json:"model"`
+	Prompt  string        `json:"prompt"`
+	System  string        `json:"system"`
+	Stream  bool          `json:"stream"`
+	Format  string        `json:"format,omitempty"`
+	Options ollamaOptions `json:"options"`
+}
+
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type ollamaGenerateResponse struct {
+	Model    string `json:"model"`
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+}
+
+func newOllamaLLMClient(options LLMOptions) *ollamaLLMClient {
+	return &ollamaLLMClient{
+		options:    options,
+		httpClient: &http.Client{Timeout: options.Timeout},
+	}
+}
+
+func (c *ollamaLLMClient) Generate(ctx context.Context, request LLMRequest) (string, error) {
+	ollamaRequest := ollamaGenerateRequest{
+		Model:  c.options.Model,
+		Prompt: request.Prompt,
+		System: request.System,
+		Stream: false,
+		Options: ollamaOptions{
+			Temperature: c.options.Temperature,
+			NumPredict:  c.options.MaxTokens,
+		},
+	}
+	if c.options.JSONMode {
+		ollamaRequest.Format = "json"
+	}
+
+	requestData, err := json.Marshal(ollamaRequest)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.options.Endpoint, bytes.NewBuffer(requestData))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &llmStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(responseBody)}
+	}
+
+	var ollamaResponse ollamaGenerateResponse
+	err = json.Unmarshal(responseBody, &ollamaResponse)
+	if err != nil {
+		return "", fmt.Errorf("error processing Ollama response: %w", err)
+	}
+
+	return ollamaResponse.Response, nil
+}
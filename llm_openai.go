@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openAILLMClient talks to anything serving the OpenAI chat completions API,
+// e.g. llama.cpp's server, vLLM or OpenAI itself. LLM_ENDPOINT is the base
+// URL (http://localhost:8000/v1), /chat/completions is added if it's missing.
+type openAILLMClient struct {
+	options    LLMOptions
+	url        string
+	httpClient *http.Client
+}
+
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIChatMessage   `json:"messages"`
+	Temperature    float64               `json:"temperature"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIChatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func newOpenAILLMClient(options LLMOptions) *openAILLMClient {
+	url := strings.TrimSuffix(options.Endpoint, "/")
+	if !strings.HasSuffix(url, "/chat/completions") {
+		url += "/chat/completions"
+	}
+
+	return &openAILLMClient{
+		options:    options,
+		url:        url,
+		httpClient: &http.Client{Timeout: options.Timeout},
+	}
+}
+
+func (c *openAILLMClient) Generate(ctx context.Context, request LLMRequest) (string, error) {
+	chatRequest := openAIChatRequest{
+		Model: c.options.Model,
+		Messages: []openAIChatMessage{
+			{Role: "system", Content: request.System},
+			{Role: "user", Content: request.Prompt},
+		},
+		Temperature: c.options.Temperature,
+		MaxTokens:   c.options.MaxTokens,
+	}
+	if c.options.JSONMode {
+		chatRequest.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	}
+
+	requestData, err := json.Marshal(chatRequest)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewBuffer(requestData))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.options.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.options.APIKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request to LLM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &llmStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(responseBody)}
+	}
+
+	var chatResponse openAIChatResponse
+	err = json.Unmarshal(responseBody, &chatResponse)
+	if err != nil {
+		return "", fmt.Errorf("error processing LLM response: %w", err)
+	}
+
+	if len(chatResponse.Choices) == 0 {
+		return "", fmt.Errorf("LLM response had no choices")
+	}
+
+	return chatResponse.Choices[0].Message.Content, nil
+}
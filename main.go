@@ -1,12 +1,17 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/austinmoody/aidea-activity-tracking/tempo"
 	"github.com/joho/godotenv"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -25,29 +30,96 @@ var (
 	// get that until something else can happen
 	ollamaGenEndpoint string
 	ollamaGenModel    string
+	// Which LLM the durationizer asks and how, see llm_client.go
+	llmProvider       string
+	llmOptions        LLMOptions
+	llmFakeResponse   string
 	autoGrades        []string
 	jiraTempoEndpoint string
+	// Tempo worklogs are created as this Jira user with these work attributes
+	tempoClient          *tempo.Client
+	tempoAuthorAccountId string
+	tempoWorkAttributes  []tempo.WorkAttribute
+	// Whether worklogs name the Jira issue by id (Tempo Cloud v4) or key
+	tempoIssueField string
+	// Pushes to Tempo wait here until they go through, retried with backoff
+	tempoOutboxDir         string
+	tempoOutboxInterval    time.Duration
+	tempoOutboxMaxAttempts int
+	// Distance cutoffs for the A,B,C,D grades and per project/Jira auto-categorize grades
+	gradeThresholds    []GradeThreshold
+	autoGradeOverrides []AutoCategorizeOverride
+	// Save confirmed/corrected activity categorizations back as example rules
+	learnRules                  bool
+	learnRulesDuplicateDistance float64
+	// Rules closer than this with a different project/task/jira are a conflict,
+	// optionally refused when uploading
+	ruleConflictDistance float64
+	ruleConflictReject   bool
+	// Every rule change is appended here, and whole rule sets saved here to restore
+	ruleHistoryFile string
+	ruleSnapshotDir string
+	// How many of the closest rules to keep on an activity
+	candidateCount int
+	// Where prompt templates and the glossary are read from
+	promptDir string
+	// Categorizers to try in order e.g. weaviate,local, and the local categorizer's copy of the rules
+	categorizerChainTypes  []string
+	localRulesFile         string
+	localRulesSyncInterval time.Duration
+	localEmbedEndpoint     string
+	localEmbedModel        string
+	// Save activities straight away and work out the rest in the background
+	asyncProcessing     bool
+	processingWorkers   int
+	processingQueueSize int
+	// csv (default, one file per day) or sqlite
+	activityStoreType  string
+	activitySqliteFile string
 )
 
 type Activity struct {
-	ActivityId             string    `json:"activity_id"`
-	WeaviateId             string    `json:"weaviate_id"`
-	Project                string    `json:"project"`
-	Task                   string    `json:"task"`
-	Jira                   string    `json:"jira"`
-	InputDescription       string    `json:"input_description"`
-	RuleDescription        string    `json:"rule_description"`
-	CategorizationDistance float64   `json:"categorization_distance"`
-	CategorizationGrade    string    `json:"categorization_grade"`
-	Duration               string    `json:"duration"`
-	Categorized            bool      `json:"categorized"`
-	PostedToJiraTempo      bool      `json:"posted_to_jira_tempo"`
-	CreatedAt              time.Time `json:"created_at"`
+	ActivityId             string          `json:"activity_id"`
+	WeaviateId             string          `json:"weaviate_id"`
+	Project                string          `json:"project"`
+	Task                   string          `json:"task"`
+	Jira                   string          `json:"jira"`
+	InputDescription       string          `json:"input_description"`
+	RuleDescription        string          `json:"rule_description"`
+	CategorizationDistance float64         `json:"categorization_distance"`
+	CategorizationGrade    string          `json:"categorization_grade"`
+	Duration               string          `json:"duration"`
+	TimeSpent              time.Duration   `json:"time_spent"` // Duration as a value, nanoseconds in JSON
+	Categorized            bool            `json:"categorized"`
+	PostedToJiraTempo      bool            `json:"posted_to_jira_tempo"`
+	CreatedAt              time.Time       `json:"created_at"`
+	ManuallyEdited         bool            `json:"manually_edited"` // changed by hand, recategorization leaves it alone
+	StartedAt              time.Time       `json:"started_at"`      // when the work happened, zero if the description didn't say
+	EndedAt                time.Time       `json:"ended_at"`
+	Candidates             []CandidateRule `json:"candidates"`              // closest rules, best first
+	PromptVersion          string          `json:"prompt_version"`          // categorizer prompt used, see prompts.go
+	TempoWorklogId         int             `json:"tempo_worklog_id"`        // set once posted, 0 before
+	TempoState             string          `json:"tempo_state"`             // pending, sent or failed once pushed, see tempo_outbox.go
+	CategorizedBy          string          `json:"categorized_by"`          // categorizer in the chain that found the rule, none if none did
+	DurationSource         string          `json:"duration_source"`         // parsed, default, llm or fallback
+	DurationPromptVersion  string          `json:"duration_prompt_version"` // duration prompt used when the LLM was asked, empty otherwise
+	ProcessingErrors       []string        `json:"processing_errors"`       // what failed along the way, the activity is saved regardless
+	Status                 string          `json:"status"`                  // pending until the duration and categorization are worked out, then processed
+}
+
+// WorkDate is when the work actually happened, which isn't necessarily when it
+// was submitted ("yesterday 2-4pm ..."). Daily files and Tempo go by this.
+func (a Activity) WorkDate() time.Time {
+	if !a.StartedAt.IsZero() {
+		return a.StartedAt
+	}
+	return a.CreatedAt
 }
 
 func init() {
+	// Without a .env everything comes from the environment, as under go test
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
 
@@ -66,21 +138,255 @@ func init() {
 	ollamaGenEndpoint = os.Getenv("OLLAMA_GEN_ENDPOINT")
 	ollamaGenModel = os.Getenv("OLLAMA_GEN_MODEL")
 
+	// LLM_PROVIDER is ollama (default), openai for any OpenAI compatible server
+	// (llama.cpp, vLLM, ...) or fake. The endpoint and model fall back to the
+	// OLLAMA_GEN_* settings. The LLM only extracts durations, LLM_TEMPERATURE
+	// defaults to 0 so the same description always gets the same answer.
+	llmProvider = os.Getenv("LLM_PROVIDER")
+	llmOptions = LLMOptions{
+		Endpoint:    cmp.Or(os.Getenv("LLM_ENDPOINT"), ollamaGenEndpoint),
+		Model:       cmp.Or(os.Getenv("LLM_MODEL"), ollamaGenModel),
+		APIKey:      os.Getenv("LLM_API_KEY"),
+		Timeout:     60 * time.Second,
+		Retries:     2,
+		Temperature: 0,
+		MaxTokens:   2000,
+		JSONMode:    os.Getenv("LLM_JSON_MODE") == "true",
+	}
+	if value := os.Getenv("LLM_TIMEOUT"); value != "" {
+		llmOptions.Timeout, err = time.ParseDuration(value)
+		if err != nil || llmOptions.Timeout <= 0 {
+			log.Fatal("LLM_TIMEOUT must be a duration like 60s")
+		}
+	}
+	if value := os.Getenv("LLM_RETRIES"); value != "" {
+		llmOptions.Retries, err = strconv.Atoi(value)
+		if err != nil || llmOptions.Retries < 0 {
+			log.Fatal("LLM_RETRIES must be zero or more")
+		}
+	}
+	if value := os.Getenv("LLM_TEMPERATURE"); value != "" {
+		llmOptions.Temperature, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Fatal("LLM_TEMPERATURE must be a number")
+		}
+	}
+	// What the fake provider answers with, for running without a model
+	llmFakeResponse = os.Getenv("LLM_FAKE_RESPONSE")
+
 	// Whatever is set here will be "categorized".  So, currently when categorizer
 	// runs it looks as the distance and determines a A,B,C,D,F "grade". Whatever
 	// is set here gets automatically categorized. So if you have this set to A,B
 	// and the match is determined to be C the categorization won't be saved
 	autoGrades = strings.Split(os.Getenv("AUTO_CATEGORIZE_GRADES"), ",")
 
+	// Distance has to be under a grade's threshold to get it, e.g. A:0.2,B:0.4,C:0.7,D:1.0
+	gradeThresholds, err = parseGradeThresholds(os.Getenv("CATEGORIZE_GRADE_THRESHOLDS"))
+	if err != nil {
+		log.Fatal("Error reading CATEGORIZE_GRADE_THRESHOLDS: ", err)
+	}
+
+	// Overrides of AUTO_CATEGORIZE_GRADES for a project name or Jira key, numbered
+	// the same way as the projects:
+	//   AUTO_CATEGORIZE_OVERRIDE_1_MATCH=FEDS-148
+	//   AUTO_CATEGORIZE_OVERRIDE_1_GRADES=A
+	for i := 1; ; i++ {
+		match := os.Getenv(fmt.Sprintf("AUTO_CATEGORIZE_OVERRIDE_%d_MATCH", i))
+
+		// Break if no more overrides
+		if match == "" {
+			break
+		}
+
+		autoGradeOverrides = append(autoGradeOverrides, AutoCategorizeOverride{
+			Match:  match,
+			Grades: strings.Split(os.Getenv(fmt.Sprintf("AUTO_CATEGORIZE_OVERRIDE_%d_GRADES", i)), ","),
+		})
+	}
+
+	// Base URL of the Tempo API e.g. https://api.tempo.io/4, worklogs are posted
+	// to {JIRA_TEMPO_ENDPOINT}/worklogs using JIRA_TEMPO_TOKEN as a bearer token
 	jiraTempoEndpoint = os.Getenv("JIRA_TEMPO_ENDPOINT")
+	tempoClient = tempo.NewClient(jiraTempoEndpoint, os.Getenv("JIRA_TEMPO_TOKEN"))
+	tempoAuthorAccountId = os.Getenv("JIRA_TEMPO_AUTHOR_ACCOUNT_ID")
+
+	// Work attributes added to every worklog, e.g. _Account_=ACC1,_Category_=Development
+	tempoWorkAttributes, err = parseTempoWorkAttributes(os.Getenv("JIRA_TEMPO_WORK_ATTRIBUTES"))
+	if err != nil {
+		log.Fatal("Error reading JIRA_TEMPO_WORK_ATTRIBUTES: ", err)
+	}
+
+	// Tempo Cloud's v4 API (the default endpoint) needs the numeric issue id, the
+	// id for a key like FEDS-148 is read from Jira at JIRA_BASE_URL using
+	// JIRA_EMAIL and JIRA_API_TOKEN. Set to key for APIs that take the key.
+	tempoIssueField = cmp.Or(os.Getenv("JIRA_TEMPO_ISSUE_FIELD"), tempoIssueById)
+	if tempoIssueField != tempoIssueById && tempoIssueField != tempoIssueByKey {
+		log.Fatal("JIRA_TEMPO_ISSUE_FIELD must be id or key")
+	}
+	jiraIssues = newJiraIssueResolver(os.Getenv("JIRA_BASE_URL"), os.Getenv("JIRA_EMAIL"), os.Getenv("JIRA_API_TOKEN"))
+
+	tempoOutboxDir = os.Getenv("JIRA_TEMPO_OUTBOX_DIR")
+	if tempoOutboxDir == "" {
+		tempoOutboxDir = "tempo_outbox"
+	}
+
+	tempoOutboxInterval = 30 * time.Second
+	if value := os.Getenv("JIRA_TEMPO_OUTBOX_INTERVAL"); value != "" {
+		tempoOutboxInterval, err = time.ParseDuration(value)
+		if err != nil || tempoOutboxInterval <= 0 {
+			log.Fatal("JIRA_TEMPO_OUTBOX_INTERVAL must be a duration like 30s")
+		}
+	}
+
+	tempoOutboxMaxAttempts = 8
+	if value := os.Getenv("JIRA_TEMPO_OUTBOX_MAX_ATTEMPTS"); value != "" {
+		tempoOutboxMaxAttempts, err = strconv.Atoi(value)
+		if err != nil || tempoOutboxMaxAttempts < 1 {
+			log.Fatal("JIRA_TEMPO_OUTBOX_MAX_ATTEMPTS must be a positive number")
+		}
+	}
+
+	learnRules = os.Getenv("LEARN_RULES") == "true"
+	learnRulesDuplicateDistance = 0.1
+	if value := os.Getenv("LEARN_RULES_DUPLICATE_DISTANCE"); value != "" {
+		learnRulesDuplicateDistance, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Fatal("LEARN_RULES_DUPLICATE_DISTANCE must be a number")
+		}
+	}
+
+	ruleConflictDistance = 0.1
+	if value := os.Getenv("RULE_CONFLICT_DISTANCE"); value != "" {
+		ruleConflictDistance, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Fatal("RULE_CONFLICT_DISTANCE must be a number")
+		}
+	}
+	ruleConflictReject = os.Getenv("RULE_CONFLICT_REJECT") == "true"
+
+	ruleHistoryFile = cmp.Or(os.Getenv("RULE_HISTORY_FILE"), "aidea_rule_history.jsonl")
+	ruleSnapshotDir = cmp.Or(os.Getenv("RULE_SNAPSHOT_DIR"), "rule_snapshots")
+
+	candidateCount = 3
+	if value := os.Getenv("CATEGORIZE_CANDIDATES"); value != "" {
+		candidateCount, err = strconv.Atoi(value)
+		if err != nil || candidateCount < 1 {
+			log.Fatal("CATEGORIZE_CANDIDATES must be a positive number")
+		}
+	}
+
+	promptDir = os.Getenv("PROMPT_DIR")
+	if promptDir == "" {
+		promptDir = "prompts"
+	}
+
+	// CATEGORIZER on its own still works for a single categorizer
+	categorizerChainTypes = strings.Split(cmp.Or(os.Getenv("CATEGORIZER_CHAIN"), os.Getenv("CATEGORIZER"), "weaviate"), ",")
+	localRulesFile = os.Getenv("LOCAL_RULES_FILE")
+	if localRulesFile == "" {
+		localRulesFile = "aidea_rules.json"
+	}
+	localRulesSyncInterval = 15 * time.Minute
+	if value := os.Getenv("LOCAL_RULES_SYNC_INTERVAL"); value != "" {
+		// 0 turns syncing off, e.g. running with only the local categorizer
+		localRulesSyncInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("LOCAL_RULES_SYNC_INTERVAL must be a duration like 15m")
+		}
+	}
+	// An Ollama /api/embeddings URL to match on embeddings rather than keywords
+	localEmbedEndpoint = os.Getenv("LOCAL_EMBED_ENDPOINT")
+	localEmbedModel = cmp.Or(os.Getenv("LOCAL_EMBED_MODEL"), weaviateEmbedModel)
+
+	asyncProcessing = os.Getenv("ASYNC_PROCESSING") == "true"
+	processingWorkers = 2
+	if value := os.Getenv("PROCESSING_WORKERS"); value != "" {
+		processingWorkers, err = strconv.Atoi(value)
+		if err != nil || processingWorkers < 1 {
+			log.Fatal("PROCESSING_WORKERS must be a positive number")
+		}
+	}
+	processingQueueSize = 100
+	if value := os.Getenv("PROCESSING_QUEUE_SIZE"); value != "" {
+		processingQueueSize, err = strconv.Atoi(value)
+		if err != nil || processingQueueSize < 1 {
+			log.Fatal("PROCESSING_QUEUE_SIZE must be a positive number")
+		}
+	}
+
+	activityStoreType = os.Getenv("ACTIVITY_STORE")
+	activitySqliteFile = os.Getenv("ACTIVITY_SQLITE_FILE")
+	if activitySqliteFile == "" {
+		activitySqliteFile = "aidea_activity_tracking.db"
+	}
 }
 
 func main() {
 
 	log.Printf("startup - AIdea Activity Tracker")
 
-	// check the weaviate collection
-	collectionCheck()
+	// go run . evaluate -input labelled.csv, see evaluation.go
+	evaluating := len(os.Args) > 1 && os.Args[1] == "evaluate"
+
+	ruleHistory = &ruleHistoryStore{file: ruleHistoryFile}
+
+	var err error
+	localRules, err = newLocalCategorizer(localRulesFile, localEmbedEndpoint, localEmbedModel)
+	if err != nil {
+		log.Fatal("issue loading local rules: ", err)
+	}
+	if localRulesSyncInterval > 0 && evaluating {
+		// Once, so every description is evaluated against the same rules
+		if err := localRules.Sync(context.Background()); err != nil {
+			log.Printf("startup - error syncing local rules, using the saved copy: %v", err)
+		}
+	} else if localRulesSyncInterval > 0 {
+		go localRules.syncEvery(localRulesSyncInterval)
+	}
+
+	categorizer, err = newCategorizerChain(categorizerChainTypes)
+	if err != nil {
+		log.Fatal("issue setting up categorizer: ", err)
+	}
+	log.Printf("startup - categorizing with '%s'", categorizer.Name())
+
+	// check the weaviate collection, only fatal if there's nothing to fall back to
+	if categorizer.uses("weaviate") {
+		if err := collectionCheck(); err != nil {
+			if len(categorizer) == 1 {
+				log.Fatal("issue checking Weaviate collection: ", err)
+			}
+			log.Printf("startup - Weaviate collection check failed, continuing with fallbacks: %v", err)
+		}
+	}
+
+	if evaluating {
+		if err := runEvaluation(os.Args[2:]); err != nil {
+			log.Fatal("issue running evaluation: ", err)
+		}
+		return
+	}
+
+	activityStore, err = newActivityStore(activityStoreType)
+	if err != nil {
+		log.Fatal("issue opening activity store: ", err)
+	}
+
+	llmClient, err = newLLMClient(llmProvider, llmOptions)
+	if err != nil {
+		log.Fatal("issue setting up LLM client: ", err)
+	}
+
+	if asyncProcessing {
+		processingQueue = newActivityProcessor(processingWorkers, processingQueueSize)
+		go processingQueue.requeuePending()
+	}
+
+	tempoQueue, err = newTempoOutbox(tempoOutboxDir)
+	if err != nil {
+		log.Fatal("issue opening Tempo outbox: ", err)
+	}
+	go tempoQueue.run(tempoOutboxInterval)
 
 	mux := http.NewServeMux()
 
@@ -90,9 +396,10 @@ func main() {
 	mux.Handle("/api/v1/rule/", &RuleManager{})
 	mux.Handle("/api/v1/project", &ProjectManager{})
 	mux.Handle("/api/v1/project/", &ProjectManager{})
+	mux.Handle("/api/v1/events", &EventManager{})
 
 	log.Printf("startup - server on port '%s'", trackerPort)
-	err := http.ListenAndServe(fmt.Sprintf(":%s", trackerPort), mux)
+	err = http.ListenAndServe(fmt.Sprintf(":%s", trackerPort), mux)
 	if err != nil {
 		log.Fatal("issue starting server: ", err)
 	}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The prompts shipped with the tracker, used for any file that isn't in PROMPT_DIR
+//
+//go:embed prompts/*.tmpl prompts/glossary.txt
+var defaultPrompts embed.FS
+
+const glossaryFile = "glossary.txt"
+
+// PromptData is what a prompt template can use
+type PromptData struct {
+	Today    string
+	Glossary string
+}
+
+type promptFile struct {
+	modTime time.Time
+	content string
+}
+
+var (
+	promptMu    sync.Mutex
+	promptCache = make(map[string]promptFile)
+)
+
+// renderPrompt fills in the named template (e.g. "categorizer.tmpl") and
+// returns it along with its version. Files in PROMPT_DIR are re-read when
+// they change so prompts can be tweaked without a restart.
+//
+// The version is the template name plus a hash of the template and glossary,
+// recorded on activities to trace which prompt produced a categorization.
+func renderPrompt(name string) (string, string, error) {
+	source, err := readPromptFile(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	glossary, err := readPromptFile(glossaryFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	tmpl, err := template.New(name).Parse(source)
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing prompt '%s': %v", name, err)
+	}
+
+	data := PromptData{
+		Today:    time.Now().Format("2006-01-02"),
+		Glossary: strings.TrimSpace(glossary),
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", "", fmt.Errorf("error rendering prompt '%s': %v", name, err)
+	}
+
+	hash := sha256.Sum256([]byte(source + glossary))
+	version := fmt.Sprintf("%s@%s", strings.TrimSuffix(name, filepath.Ext(name)), hex.EncodeToString(hash[:])[:8])
+
+	return rendered.String(), version, nil
+}
+
+// Read a prompt file from PROMPT_DIR if it's there, otherwise the built in copy
+func readPromptFile(name string) (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	filename := filepath.Join(promptDir, name)
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("error checking prompt '%s': %v", filename, err)
+		}
+
+		content, err := fs.ReadFile(defaultPrompts, "prompts/"+name)
+		if err != nil {
+			return "", fmt.Errorf("no prompt named '%s': %v", name, err)
+		}
+		return string(content), nil
+	}
+
+	cached, exists := promptCache[filename]
+	if exists && cached.modTime.Equal(info.ModTime()) {
+		return cached.content, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("error reading prompt '%s': %v", filename, err)
+	}
+
+	if exists {
+		log.Printf("prompts - '%s' changed, reloaded", filename)
+	}
+
+	promptCache[filename] = promptFile{modTime: info.ModTime(), content: string(content)}
+
+	return string(content), nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Kinds of rule pair the conflict report flags
+const (
+	// Different project/task/jira for the same or a nearly identical description
+	ruleConflict = "conflict"
+	// Same description and the same project/task/jira, one of them is redundant
+	ruleDuplicate = "duplicate"
+)
+
+// RuleConflict is a pair of rules that categorization can't tell apart
+type RuleConflict struct {
+	Kind string `json:"kind"`
+	// The descriptions are the same once case and spacing are ignored
+	Exact bool `json:"exact"`
+	// Cosine distance between the two rule vectors, -1 if either had none
+	Distance float64 `json:"distance"`
+	First    Rule    `json:"first"`
+	Second   Rule    `json:"second"`
+}
+
+type RuleConflictReport struct {
+	Threshold  float64        `json:"threshold"`
+	Rules      int            `json:"rules"`
+	Conflicts  int            `json:"conflicts"`
+	Duplicates int            `json:"duplicates"`
+	Pairs      []RuleConflict `json:"pairs"`
+}
+
+// Descriptions are compared ignoring case and spacing
+func normalizeRuleDescription(description string) string {
+	return strings.Join(strings.Fields(strings.ToLower(description)), " ")
+}
+
+func sameRuleTarget(a Rule, b Rule) bool {
+	return strings.EqualFold(a.Project, b.Project) &&
+		strings.EqualFold(a.Task, b.Task) &&
+		strings.EqualFold(a.Jira, b.Jira)
+}
+
+// findRuleConflicts compares every pair of rules. vectors lines up with rules,
+// a missing vector only leaves that rule out of the distance check.
+func findRuleConflicts(rules []Rule, vectors [][]float64, threshold float64) []RuleConflict {
+	descriptions := make([]string, len(rules))
+	for i, rule := range rules {
+		descriptions[i] = normalizeRuleDescription(rule.Description)
+	}
+
+	var pairs []RuleConflict
+	for i := range rules {
+		for j := i + 1; j < len(rules); j++ {
+			distance := -1.0
+			if len(vectors[i]) > 0 && len(vectors[j]) > 0 {
+				distance = max(1-embeddingSimilarity(vectors[i], vectors[j]), 0)
+			}
+
+			exact := descriptions[i] == descriptions[j]
+			near := exact || (distance >= 0 && distance < threshold)
+
+			switch {
+			case near && !sameRuleTarget(rules[i], rules[j]):
+				pairs = append(pairs, RuleConflict{Kind: ruleConflict, Exact: exact, Distance: distance, First: rules[i], Second: rules[j]})
+			case exact:
+				pairs = append(pairs, RuleConflict{Kind: ruleDuplicate, Exact: true, Distance: distance, First: rules[i], Second: rules[j]})
+			}
+		}
+	}
+
+	// Conflicts first, then the closest
+	slices.SortStableFunc(pairs, func(a, b RuleConflict) int {
+		if a.Kind != b.Kind {
+			if a.Kind == ruleConflict {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+
+	return pairs
+}
+
+// findUploadConflicts checks rules about to be saved against what's already
+// in Weaviate (nearText on each description) and against each other (exact
+// descriptions only, they don't have vectors yet). Rules are matched to
+// themselves by id so re-uploading a rule isn't a conflict.
+func findUploadConflicts(ctx context.Context, rules []Rule, threshold float64) ([]RuleConflict, error) {
+	var pairs []RuleConflict
+
+	for i, rule := range rules {
+		candidates, err := findCandidateRules(ctx, rule.Description, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, candidate := range candidates {
+			existing := Rule{
+				Id:          candidate.WeaviateId,
+				Project:     candidate.Project,
+				Task:        candidate.Task,
+				Jira:        candidate.Jira,
+				Description: candidate.Description,
+				ParentId:    candidate.ParentId,
+			}
+			if existing.Id == rule.Id || candidate.Distance >= threshold || sameRuleTarget(rule, existing) {
+				continue
+			}
+			// Also being replaced in this upload, judge it by its new values instead
+			if slices.ContainsFunc(rules, func(r Rule) bool { return r.Id == existing.Id }) {
+				continue
+			}
+
+			pairs = append(pairs, RuleConflict{
+				Kind:     ruleConflict,
+				Exact:    normalizeRuleDescription(rule.Description) == normalizeRuleDescription(existing.Description),
+				Distance: candidate.Distance,
+				First:    rule,
+				Second:   existing,
+			})
+		}
+
+		for _, other := range rules[i+1:] {
+			if normalizeRuleDescription(rule.Description) == normalizeRuleDescription(other.Description) && !sameRuleTarget(rule, other) {
+				pairs = append(pairs, RuleConflict{Kind: ruleConflict, Exact: true, Distance: -1, First: rule, Second: other})
+			}
+		}
+	}
+
+	return pairs, nil
+}
+
+// rejectConflicts is RULE_CONFLICT_REJECT unless the upload says otherwise
+// with ?reject_conflicts=true/false
+func rejectConflicts(r *http.Request) (bool, error) {
+	reject, err := parseOptionalBool(r.URL.Query(), "reject_conflicts")
+	if err != nil {
+		return false, err
+	}
+	if reject == nil {
+		return ruleConflictReject, nil
+	}
+	return *reject, nil
+}
+
+// checkUploadConflicts writes a 409 with the conflicts (or an error) and
+// returns false if the rules shouldn't be saved
+func checkUploadConflicts(w http.ResponseWriter, r *http.Request, rules []Rule) bool {
+	reject, err := rejectConflicts(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if !reject {
+		return true
+	}
+
+	conflicts, err := findUploadConflicts(r.Context(), rules, ruleConflictDistance)
+	if err != nil {
+		http.Error(w, "Error checking rules for conflicts: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if len(conflicts) == 0 {
+		return true
+	}
+
+	log.Printf("\trejecting upload, %d conflicting rule pairs", len(conflicts))
+
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   fmt.Sprintf("%d conflicting rule pairs, nothing was saved", len(conflicts)),
+		"threshold": ruleConflictDistance,
+		"conflicts": conflicts,
+	})
+	return false
+}
+
+// GET /api/v1/rule/conflicts?threshold=0.1
+func (h *RuleManager) getRuleConflicts(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("rule manager - rule conflicts request received: %s", r.URL.RawQuery)
+
+	threshold := ruleConflictDistance
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 2 {
+			http.Error(w, fmt.Sprintf("invalid threshold '%s', must be a distance from 0 to 2", value), http.StatusBadRequest)
+			return
+		}
+		threshold = parsed
+	}
+
+	objects, err := fetchRuleObjects(r.Context(), true)
+	if err != nil {
+		log.Printf("\terror fetching rules: %v", err)
+		http.Error(w, "Error reading rules from Weaviate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rules := make([]Rule, len(objects))
+	vectors := make([][]float64, len(objects))
+	for i, object := range objects {
+		rules[i] = ruleFromObject(object)
+		for _, value := range object.Vector {
+			vectors[i] = append(vectors[i], float64(value))
+		}
+	}
+
+	report := RuleConflictReport{
+		Threshold: threshold,
+		Rules:     len(rules),
+		Pairs:     findRuleConflicts(rules, vectors, threshold),
+	}
+	if report.Pairs == nil {
+		report.Pairs = []RuleConflict{}
+	}
+	for _, pair := range report.Pairs {
+		if pair.Kind == ruleConflict {
+			report.Conflicts++
+		} else {
+			report.Duplicates++
+		}
+	}
+
+	log.Printf("\t%d rules, %d conflicts, %d duplicates", report.Rules, report.Conflicts, report.Duplicates)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeRuleDescription(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Release Notes", "release notes"},
+		{"  release\tnotes \n", "release notes"},
+		{"release  notes for FEDS", "release notes for feds"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := normalizeRuleDescription(test.input); got != test.want {
+			t.Errorf("normalizeRuleDescription(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestFindRuleConflicts(t *testing.T) {
+	feds := Rule{Id: "feds", Project: "FEDS", Task: "Release", Jira: "FEDS-148", Description: "release notes"}
+	fedsAgain := Rule{Id: "feds-again", Project: "feds", Task: "release", Jira: "feds-148", Description: "Release  Notes"}
+	izg := Rule{Id: "izg", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "release notes"}
+	izgNear := Rule{Id: "izg-near", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "writing the release notes"}
+	standup := Rule{Id: "standup", Project: "OPS", Task: "Meetings", Jira: "OPS-1", Description: "daily standup"}
+
+	type pair struct {
+		kind          string
+		first, second string
+	}
+
+	tests := []struct {
+		name    string
+		rules   []Rule
+		vectors [][]float64
+		want    []pair
+	}{
+		{
+			"exact description, different target",
+			[]Rule{feds, izg},
+			[][]float64{nil, nil},
+			[]pair{{ruleConflict, "feds", "izg"}},
+		},
+		{
+			"exact description, same target ignoring case",
+			[]Rule{feds, fedsAgain},
+			[][]float64{nil, nil},
+			[]pair{{ruleDuplicate, "feds", "feds-again"}},
+		},
+		{
+			"close vectors, different target",
+			[]Rule{feds, izgNear},
+			[][]float64{{1, 0}, {0.99, 0.05}},
+			[]pair{{ruleConflict, "feds", "izg-near"}},
+		},
+		{
+			"close vectors, same target",
+			[]Rule{izg, izgNear},
+			[][]float64{{1, 0}, {0.99, 0.05}},
+			nil,
+		},
+		{
+			"far vectors",
+			[]Rule{feds, standup},
+			[][]float64{{1, 0}, {0, 1}},
+			nil,
+		},
+		{
+			"missing vector skips the distance check",
+			[]Rule{feds, izgNear},
+			[][]float64{{1, 0}, nil},
+			nil,
+		},
+		{
+			"conflicts before duplicates",
+			[]Rule{feds, fedsAgain, izgNear},
+			[][]float64{{1, 0}, {1, 0}, {0.99, 0.05}},
+			[]pair{{ruleConflict, "feds", "izg-near"}, {ruleConflict, "feds-again", "izg-near"}, {ruleDuplicate, "feds", "feds-again"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conflicts := findRuleConflicts(test.rules, test.vectors, 0.1)
+
+			got := make([]pair, len(conflicts))
+			for i, conflict := range conflicts {
+				got[i] = pair{conflict.Kind, conflict.First.Id, conflict.Second.Id}
+				// Only an exact description gets in without a distance under the threshold
+				if !conflict.Exact && (conflict.Distance < 0 || conflict.Distance >= 0.1) {
+					t.Errorf("pair %v has distance %f", got[i], conflict.Distance)
+				}
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("findRuleConflicts() = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("findRuleConflicts() = %v, want %v", got, test.want)
+					break
+				}
+			}
+		})
+	}
+}
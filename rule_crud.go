@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate/entities/models"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var ruleById *regexp.Regexp
+
+func init() {
+	ruleById = regexp.MustCompile(`^/api/v1/rule/([0-9a-f-]+)$`)
+}
+
+var errRuleNotFound = errors.New("rule not found")
+
+type RuleList struct {
+	Rules    []Rule `json:"rules"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+	Total    int    `json:"total"`
+}
+
+// Filters for the rule listing, project/task/jira match exactly (ignoring
+// case) and q is searched for in all of them and the description
+type ruleFilter struct {
+	project string
+	task    string
+	jira    string
+	q       string
+}
+
+func parseRuleFilter(query url.Values) ruleFilter {
+	return ruleFilter{
+		project: query.Get("project"),
+		task:    query.Get("task"),
+		jira:    query.Get("jira"),
+		q:       strings.ToLower(strings.TrimSpace(query.Get("q"))),
+	}
+}
+
+func (f ruleFilter) matches(rule Rule) bool {
+	if f.project != "" && !strings.EqualFold(f.project, rule.Project) {
+		return false
+	}
+	if f.task != "" && !strings.EqualFold(f.task, rule.Task) {
+		return false
+	}
+	if f.jira != "" && !strings.EqualFold(f.jira, rule.Jira) {
+		return false
+	}
+	if f.q != "" {
+		text := strings.ToLower(strings.Join([]string{rule.Project, rule.Task, rule.Jira, rule.Description}, " "))
+		if !strings.Contains(text, f.q) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *RuleManager) listRules(w http.ResponseWriter, r *http.Request) {
+
+	log.Printf("rule manager - list rules request received: %s", r.URL.RawQuery)
+
+	query := r.URL.Query()
+	filter := parseRuleFilter(query)
+
+	page, pageSize, err := parsePaging(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rules, err := fetchAllRules(r.Context())
+	if err != nil {
+		log.Printf("\terror fetching rules: %v", err)
+		http.Error(w, "Error reading rules from Weaviate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var matched []Rule
+	for _, rule := range rules {
+		if filter.matches(rule) {
+			matched = append(matched, rule)
+		}
+	}
+
+	response := RuleList{
+		Rules:    []Rule{},
+		Page:     page,
+		PageSize: pageSize,
+		Total:    len(matched),
+	}
+
+	// Past the last page is an empty page, checked before multiplying out the start
+	if page-1 < (len(matched)+pageSize-1)/pageSize {
+		start := (page - 1) * pageSize
+		end := min(start+pageSize, len(matched))
+		response.Rules = matched[start:end]
+	}
+
+	log.Printf("\treturning %d of %d matching rules", len(response.Rules), response.Total)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *RuleManager) getRuleById(w http.ResponseWriter, r *http.Request) {
+
+	ruleId := ruleById.FindStringSubmatch(r.URL.Path)[1]
+
+	log.Printf("rule manager - get rule '%s'", ruleId)
+
+	rule, err := getRuleFromWeaviate(r.Context(), ruleId)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (h *RuleManager) deleteRule(w http.ResponseWriter, r *http.Request) {
+
+	ruleId := ruleById.FindStringSubmatch(r.URL.Path)[1]
+
+	log.Printf("rule manager - delete rule '%s'", ruleId)
+
+	rule, err := getRuleFromWeaviate(r.Context(), ruleId)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	if err := removeRule(r.Context(), rule); err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	log.Printf("\trule '%s' (%s) deleted", ruleId, rule.Jira)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func writeRuleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRuleNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "error with Weaviate: "+err.Error(), http.StatusInternalServerError)
+}
+
+func getRuleFromWeaviate(ctx context.Context, ruleId string) (Rule, error) {
+	client, err := weaviate.NewClient(weaviateConfig)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	objects, err := client.Data().ObjectsGetter().
+		WithClassName(weaviateClass).
+		WithID(ruleId).
+		Do(ctx)
+	if weaviateNotFound(err) || (err == nil && len(objects) == 0) {
+		return Rule{}, errRuleNotFound
+	}
+	if err != nil {
+		return Rule{}, err
+	}
+
+	return ruleFromObject(objects[0]), nil
+}
+
+func deleteRuleFromWeaviate(ctx context.Context, ruleId string) error {
+	client, err := weaviate.NewClient(weaviateConfig)
+	if err != nil {
+		return err
+	}
+
+	err = client.Data().Deleter().
+		WithClassName(weaviateClass).
+		WithID(ruleId).
+		Do(ctx)
+	if weaviateNotFound(err) {
+		return errRuleNotFound
+	}
+	return err
+}
+
+func weaviateNotFound(err error) bool {
+	wce := &fault.WeaviateClientError{}
+	return errors.As(err, &wce) && wce.StatusCode == http.StatusNotFound
+}
+
+func ruleFromObject(object *models.Object) Rule {
+	properties, _ := object.Properties.(map[string]interface{})
+	return Rule{
+		Id:          object.ID.String(),
+		Project:     stringProperty(properties, "project"),
+		Task:        stringProperty(properties, "task"),
+		Jira:        stringProperty(properties, "jira"),
+		Description: stringProperty(properties, "description"),
+		// Rules saved before parentId existed don't have it at all
+		ParentId: stringProperty(properties, "parentId"),
+	}
+}
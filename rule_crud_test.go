@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestListRulesPaging(t *testing.T) {
+	newRuleTest(t)
+
+	for i := range 3 {
+		body := fmt.Sprintf(`{"id": "00000000-0000-0000-0000-00000000000%d", "project": "FEDS", "jira": "FEDS-%d", "description": "rule %d"}`, i, i, i)
+		if code := ruleRequest(t, http.MethodPost, "/api/v1/rule", body, nil); code != http.StatusCreated {
+			t.Fatalf("creating rule %d returned %d", i, code)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantRules int
+	}{
+		{"first page", "page_size=2", http.StatusOK, 2},
+		{"last page", "page=2&page_size=2", http.StatusOK, 1},
+		{"past the end", "page=3&page_size=2", http.StatusOK, 0},
+		{"largest page", "page=" + strconv.Itoa(maxActivityPage) + "&page_size=" + strconv.Itoa(maxActivityPageSize), http.StatusOK, 0},
+		{"page that would overflow", "page=9223372036854775807&page_size=500", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var list RuleList
+			code := ruleRequest(t, http.MethodGet, "/api/v1/rule/list?"+test.query, "", &list)
+			if code != test.wantCode {
+				t.Fatalf("list returned %d, want %d", code, test.wantCode)
+			}
+			if code == http.StatusOK && (len(list.Rules) != test.wantRules || list.Total != 3) {
+				t.Errorf("list returned %d of %d rules, want %d of 3", len(list.Rules), list.Total, test.wantRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type RuleTestRequest struct {
+	Description string `json:"description"`
+}
+
+// RuleTestCandidate is a candidate rule and whether its grade alone would be
+// enough to categorize with it under AUTO_CATEGORIZE_GRADES
+type RuleTestCandidate struct {
+	CandidateRule
+	AutoApplies bool `json:"auto_applies"`
+}
+
+// RuleTestResult is what categorizing the description would have done.
+// Only the closest candidate is ever applied, WouldAutoApply says if it would be.
+type RuleTestResult struct {
+	Description    string              `json:"description"`
+	WouldAutoApply bool                `json:"would_auto_apply"`
+	Project        string              `json:"project"`
+	Task           string              `json:"task"`
+	Jira           string              `json:"jira"`
+	Grade          string              `json:"grade"`
+	AutoGrades     []string            `json:"auto_grades"` // for the closest candidate's project/Jira
+	CategorizedBy  string              `json:"categorized_by"`
+	PromptVersion  string              `json:"prompt_version,omitempty"`
+	Errors         []string            `json:"errors,omitempty"`
+	Candidates     []RuleTestCandidate `json:"candidates"`
+}
+
+// testRule categorizes a description the way a new activity would be, without
+// saving anything
+func (h *RuleManager) testRule(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("rule manager - rule test request received")
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		http.Error(w, "content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var request RuleTestRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Description) == "" {
+		http.Error(w, "description is required", http.StatusBadRequest)
+		return
+	}
+
+	activity := categorizeActivity(Activity{InputDescription: request.Description})
+
+	result := RuleTestResult{
+		Description:    request.Description,
+		WouldAutoApply: activity.Categorized,
+		Project:        activity.Project,
+		Task:           activity.Task,
+		Jira:           activity.Jira,
+		Grade:          activity.CategorizationGrade,
+		CategorizedBy:  activity.CategorizedBy,
+		PromptVersion:  activity.PromptVersion,
+		Errors:         activity.ProcessingErrors,
+		Candidates:     []RuleTestCandidate{},
+	}
+
+	for i, candidate := range activity.Candidates {
+		grades := autoCategorizeGrades(candidate.Project, candidate.Jira)
+		if i == 0 {
+			result.AutoGrades = grades
+		}
+		result.Candidates = append(result.Candidates, RuleTestCandidate{
+			CandidateRule: candidate,
+			AutoApplies:   slices.Contains(grades, candidate.Grade),
+		})
+	}
+
+	log.Printf("\t'%s' would be %s grade %s (auto apply: %t)", request.Description, activity.Jira, activity.CategorizationGrade, result.WouldAutoApply)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ruleHistoryById     *regexp.Regexp
+	ruleRestoreById     *regexp.Regexp
+	ruleSnapshotById    *regexp.Regexp
+	ruleSnapshotRestore *regexp.Regexp
+)
+
+func init() {
+	ruleHistoryById = regexp.MustCompile(`^/api/v1/rule/([0-9a-f-]+)/history$`)
+	ruleRestoreById = regexp.MustCompile(`^/api/v1/rule/([0-9a-f-]+)/restore$`)
+	ruleSnapshotById = regexp.MustCompile(`^/api/v1/rule/snapshots/([0-9a-f-]+)$`)
+	ruleSnapshotRestore = regexp.MustCompile(`^/api/v1/rule/snapshots/([0-9a-f-]+)/restore$`)
+}
+
+const (
+	ruleActionCreate = "create"
+	ruleActionUpdate = "update"
+	ruleActionDelete = "delete"
+)
+
+// RuleHistoryEntry is one change to a rule. Before is nil for a create and
+// After is nil for a delete.
+type RuleHistoryEntry struct {
+	Id     string    `json:"id"`
+	RuleId string    `json:"rule_id"`
+	Action string    `json:"action"`
+	Before *Rule     `json:"before,omitempty"`
+	After  *Rule     `json:"after,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// ruleHistoryStore appends every rule change to RULE_HISTORY_FILE, one JSON
+// entry per line. Weaviate only has the rules as they are now.
+type ruleHistoryStore struct {
+	mu   sync.Mutex
+	file string
+}
+
+var ruleHistory *ruleHistoryStore
+
+// record never fails the change it's recording, the rule is already saved
+func (h *ruleHistoryStore) record(action string, before *Rule, after *Rule) {
+	if h == nil {
+		return
+	}
+
+	entry := RuleHistoryEntry{
+		Id:     uuid.New().String(),
+		Action: action,
+		Before: before,
+		After:  after,
+		At:     time.Now(),
+	}
+	if after != nil {
+		entry.RuleId = after.Id
+	} else if before != nil {
+		entry.RuleId = before.Id
+	}
+
+	if err := h.append(entry); err != nil {
+		log.Printf("rule history - error recording %s of rule '%s': %v", action, entry.RuleId, err)
+	}
+}
+
+func (h *ruleHistoryStore) append(entry RuleHistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// forRule returns a rule's changes, oldest first
+func (h *ruleHistoryStore) forRule(ruleId string) ([]RuleHistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []RuleHistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry RuleHistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("rule history - skipping unreadable entry: %v", err)
+			continue
+		}
+		if entry.RuleId == ruleId {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// removeRule deletes a rule from Weaviate and everywhere that keeps track of it
+func removeRule(ctx context.Context, rule Rule) error {
+	if err := deleteRuleFromWeaviate(ctx, rule.Id); err != nil {
+		return err
+	}
+
+	ruleHistory.record(ruleActionDelete, &rule, nil)
+	events.publish(eventRuleDeleted, rule)
+
+	// So the local categorizer stops matching it before the next sync
+	if localRules != nil {
+		if err := localRules.Remove(rule.Id); err != nil {
+			log.Printf("error updating local rules: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (h *RuleManager) getRuleHistory(w http.ResponseWriter, r *http.Request) {
+
+	ruleId := ruleHistoryById.FindStringSubmatch(r.URL.Path)[1]
+
+	log.Printf("rule manager - history of rule '%s'", ruleId)
+
+	entries, err := ruleHistory.forRule(ruleId)
+	if err != nil {
+		http.Error(w, "Error reading rule history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		http.Error(w, "no history for rule", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// restoreRule puts a rule back the way it was after one of its history
+// entries, ?version={history entry id}. Restoring a delete brings back the
+// rule as it was just before it was deleted.
+func (h *RuleManager) restoreRule(w http.ResponseWriter, r *http.Request) {
+
+	ruleId := ruleRestoreById.FindStringSubmatch(r.URL.Path)[1]
+	version := r.URL.Query().Get("version")
+
+	log.Printf("rule manager - restore rule '%s' to version '%s'", ruleId, version)
+
+	if version == "" {
+		http.Error(w, "version is required, the id of a history entry", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := ruleHistory.forRule(ruleId)
+	if err != nil {
+		http.Error(w, "Error reading rule history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	i := slices.IndexFunc(entries, func(entry RuleHistoryEntry) bool { return entry.Id == version })
+	if i < 0 {
+		http.Error(w, "version not found in the rule's history", http.StatusNotFound)
+		return
+	}
+
+	restored := entries[i].After
+	if restored == nil {
+		restored = entries[i].Before
+	}
+
+	rules := []Rule{*restored}
+	if _, err := saveRulesToWeaviate(rules); err != nil {
+		log.Printf("\terror restoring rule: %v", err)
+		http.Error(w, "Error saving rule to Weaviate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("\trule '%s' restored", ruleId)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rules[0])
+}
+
+// RuleSnapshot is the whole rule set at one point, kept as a JSON file in
+// RULE_SNAPSHOT_DIR
+type RuleSnapshot struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Count     int       `json:"count"`
+	Rules     []Rule    `json:"rules,omitempty"`
+}
+
+// snapshotRules saves every rule in Weaviate as a new snapshot
+func snapshotRules(ctx context.Context, name string) (RuleSnapshot, error) {
+	rules, err := fetchAllRules(ctx)
+	if err != nil {
+		return RuleSnapshot{}, err
+	}
+
+	snapshot := RuleSnapshot{
+		Id:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now(),
+		Count:     len(rules),
+		Rules:     rules,
+	}
+
+	if err := os.MkdirAll(ruleSnapshotDir, 0755); err != nil {
+		return snapshot, fmt.Errorf("error creating snapshot directory '%s': %w", ruleSnapshotDir, err)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return snapshot, err
+	}
+
+	file := filepath.Join(ruleSnapshotDir, snapshot.Id+".json")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return snapshot, fmt.Errorf("error writing snapshot '%s': %w", file, err)
+	}
+
+	log.Printf("rule history - snapshot '%s' of %d rules saved", snapshot.Id, snapshot.Count)
+
+	return snapshot, nil
+}
+
+func readRuleSnapshot(snapshotId string) (RuleSnapshot, error) {
+	var snapshot RuleSnapshot
+
+	data, err := os.ReadFile(filepath.Join(ruleSnapshotDir, snapshotId+".json"))
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("error parsing snapshot '%s': %w", snapshotId, err)
+	}
+
+	return snapshot, nil
+}
+
+// listRuleSnapshots returns the snapshots newest first, without their rules
+func listRuleSnapshots() ([]RuleSnapshot, error) {
+	files, err := filepath.Glob(filepath.Join(ruleSnapshotDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]RuleSnapshot, 0, len(files))
+	for _, file := range files {
+		snapshot, err := readRuleSnapshot(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			log.Printf("rule history - skipping '%s': %v", file, err)
+			continue
+		}
+		snapshot.Rules = nil
+		snapshots = append(snapshots, snapshot)
+	}
+
+	slices.SortFunc(snapshots, func(a, b RuleSnapshot) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// POST /api/v1/rule/snapshots?name=before-cleanup
+func (h *RuleManager) createRuleSnapshot(w http.ResponseWriter, r *http.Request) {
+
+	log.Println("rule manager - rule snapshot request received")
+
+	snapshot, err := snapshotRules(r.Context(), r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, "Error saving rule snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	snapshot.Rules = nil
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (h *RuleManager) listRuleSnapshots(w http.ResponseWriter) {
+
+	log.Println("rule manager - list rule snapshots request received")
+
+	snapshots, err := listRuleSnapshots()
+	if err != nil {
+		http.Error(w, "Error reading rule snapshots: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+func (h *RuleManager) getRuleSnapshot(w http.ResponseWriter, r *http.Request) {
+
+	snapshotId := ruleSnapshotById.FindStringSubmatch(r.URL.Path)[1]
+
+	log.Printf("rule manager - get rule snapshot '%s'", snapshotId)
+
+	snapshot, err := readRuleSnapshot(snapshotId)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading rule snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// RuleSnapshotRestore is what restoring a snapshot changed. The rules as they
+// were beforehand are saved as another snapshot first, so a restore can be undone too.
+type RuleSnapshotRestore struct {
+	SnapshotId       string `json:"snapshot_id"`
+	BeforeSnapshotId string `json:"before_snapshot_id"`
+	Saved            int    `json:"saved"`
+	Deleted          int    `json:"deleted"`
+}
+
+// restoreRuleSnapshot makes Weaviate hold exactly the snapshot's rules, saving
+// each of them and deleting any rule added since
+func (h *RuleManager) restoreRuleSnapshot(w http.ResponseWriter, r *http.Request) {
+
+	snapshotId := ruleSnapshotRestore.FindStringSubmatch(r.URL.Path)[1]
+
+	log.Printf("rule manager - restore rule snapshot '%s'", snapshotId)
+
+	snapshot, err := readRuleSnapshot(snapshotId)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading rule snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	before, err := snapshotRules(r.Context(), "before restoring "+cmp.Or(snapshot.Name, snapshot.Id))
+	if err != nil {
+		http.Error(w, "Error saving rule snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := RuleSnapshotRestore{SnapshotId: snapshot.Id, BeforeSnapshotId: before.Id}
+
+	for _, current := range before.Rules {
+		if slices.ContainsFunc(snapshot.Rules, func(rule Rule) bool { return rule.Id == current.Id }) {
+			continue
+		}
+		if err := removeRule(r.Context(), current); err != nil && !errors.Is(err, errRuleNotFound) {
+			http.Error(w, fmt.Sprintf("Error deleting rule '%s': %v", current.Id, err), http.StatusInternalServerError)
+			return
+		}
+		result.Deleted++
+	}
+
+	if len(snapshot.Rules) > 0 {
+		if _, err := saveRulesToWeaviate(snapshot.Rules); err != nil {
+			log.Printf("\terror restoring snapshot rules: %v", err)
+			http.Error(w, "Error saving rules to Weaviate: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Saved = len(snapshot.Rules)
+	}
+
+	log.Printf("\tsnapshot '%s' restored, %d saved, %d deleted", snapshot.Id, result.Saved, result.Deleted)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
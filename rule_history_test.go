@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWeaviate keeps rule objects in memory, enough of the REST API for
+// saving, reading, listing and deleting rules. With fail set every object
+// lookup is a 500.
+type fakeWeaviate struct {
+	mu      sync.Mutex
+	objects map[string]map[string]interface{}
+	fail    bool
+}
+
+func (f *fakeWeaviate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/v1/meta":
+		w.Write([]byte(`{"version": "1.25.0"}`))
+	case f.fail:
+		http.Error(w, `{"error": [{"message": "unavailable"}]}`, http.StatusInternalServerError)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/objects":
+		var object struct {
+			Id         string                 `json:"id"`
+			Properties map[string]interface{} `json:"properties"`
+		}
+		json.Unmarshal(body, &object)
+		f.objects[object.Id] = object.Properties
+		w.Write(body)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/objects":
+		ids := make([]string, 0, len(f.objects))
+		for id := range f.objects {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+
+		// One page, the cursor's second request gets nothing
+		objects := []interface{}{}
+		if r.URL.Query().Get("after") == "" {
+			for _, id := range ids {
+				objects = append(objects, map[string]interface{}{"id": id, "class": weaviateClass, "properties": f.objects[id]})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"objects": objects})
+	default:
+		id := parts[len(parts)-1]
+		properties, found := f.objects[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "class": weaviateClass, "properties": properties})
+		case http.MethodDelete:
+			delete(f.objects, id)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			var object struct {
+				Properties map[string]interface{} `json:"properties"`
+			}
+			json.Unmarshal(body, &object)
+			for key, value := range object.Properties {
+				properties[key] = value
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// newRuleTest points the rule globals at a fake Weaviate and temporary
+// history and snapshot files
+func newRuleTest(t *testing.T) *fakeWeaviate {
+	t.Helper()
+
+	fake := &fakeWeaviate{objects: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	previousConfig, previousClass, previousHistory := weaviateConfig, weaviateClass, ruleHistory
+	previousSnapshotDir, previousLocalRules := ruleSnapshotDir, localRules
+	t.Cleanup(func() {
+		weaviateConfig, weaviateClass, ruleHistory = previousConfig, previousClass, previousHistory
+		ruleSnapshotDir, localRules = previousSnapshotDir, previousLocalRules
+	})
+
+	dir := t.TempDir()
+	weaviateConfig = weaviate.Config{Host: strings.TrimPrefix(server.URL, "http://"), Scheme: "http"}
+	weaviateClass = "Rules"
+	ruleHistory = &ruleHistoryStore{file: filepath.Join(dir, "history.jsonl")}
+	ruleSnapshotDir = filepath.Join(dir, "snapshots")
+	localRules = nil
+
+	return fake
+}
+
+func ruleRequest(t *testing.T, method string, path string, body string, result interface{}) int {
+	t.Helper()
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	recorder := httptest.NewRecorder()
+	(&RuleManager{}).ServeHTTP(recorder, request)
+
+	if result != nil && recorder.Code < 300 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			t.Fatalf("%s %s: error parsing %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+const testRuleId = "00000000-0000-0000-0000-00000000000a"
+
+func TestRuleHistoryStore(t *testing.T) {
+	history := &ruleHistoryStore{file: filepath.Join(t.TempDir(), "history.jsonl")}
+
+	if entries, err := history.forRule(testRuleId); err != nil || entries != nil {
+		t.Fatalf("forRule() before anything was recorded = %v, %v, want nothing", entries, err)
+	}
+
+	created := Rule{Id: testRuleId, Project: "FEDS", Jira: "FEDS-148", Description: "release notes"}
+	updated := created
+	updated.Jira = "FEDS-149"
+	other := Rule{Id: "other", Project: "IZG", Description: "docs"}
+
+	history.record(ruleActionCreate, nil, &created)
+	history.record(ruleActionCreate, nil, &other)
+	history.record(ruleActionUpdate, &created, &updated)
+	history.record(ruleActionDelete, &updated, nil)
+
+	entries, err := history.forRule(testRuleId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		if entry.RuleId != testRuleId || entry.Id == "" {
+			t.Errorf("entry %+v isn't for the rule or has no id", entry)
+		}
+	}
+	if !slices.Equal(actions, []string{ruleActionCreate, ruleActionUpdate, ruleActionDelete}) {
+		t.Errorf("forRule() actions = %v, want create, update, delete", actions)
+	}
+	if entries[1].Before.Jira != "FEDS-148" || entries[1].After.Jira != "FEDS-149" || entries[2].After != nil {
+		t.Errorf("entries don't keep before and after: %+v", entries)
+	}
+
+	// Without RULE_HISTORY_FILE set up there's nothing to record to
+	var missing *ruleHistoryStore
+	missing.record(ruleActionCreate, nil, &created)
+}
+
+func TestRestoreRule(t *testing.T) {
+	newRuleTest(t)
+
+	if code := ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "`+testRuleId+`", "project": "FEDS", "task": "Release", "jira": "FEDS-148", "description": "release notes"}`, nil); code != http.StatusCreated {
+		t.Fatalf("creating the rule returned %d", code)
+	}
+	// Saving it again unchanged isn't a change
+	ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "`+testRuleId+`", "project": "FEDS", "task": "Release", "jira": "FEDS-148", "description": "release notes"}`, nil)
+	ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "`+testRuleId+`", "project": "FEDS", "task": "Release", "jira": "FEDS-149", "description": "release notes"}`, nil)
+
+	var entries []RuleHistoryEntry
+	if code := ruleRequest(t, http.MethodGet, "/api/v1/rule/"+testRuleId+"/history", "", &entries); code != http.StatusOK || len(entries) != 2 {
+		t.Fatalf("history returned %d with %d entries, want 2", code, len(entries))
+	}
+
+	if code := ruleRequest(t, http.MethodPost, "/api/v1/rule/"+testRuleId+"/restore?version="+entries[0].Id, "", nil); code != http.StatusOK {
+		t.Fatalf("restore returned %d", code)
+	}
+
+	var rule Rule
+	ruleRequest(t, http.MethodGet, "/api/v1/rule/"+testRuleId, "", &rule)
+	if rule.Jira != "FEDS-148" {
+		t.Errorf("restored rule has Jira %q, want FEDS-148", rule.Jira)
+	}
+
+	// A deleted rule comes back as it was before the delete
+	if code := ruleRequest(t, http.MethodDelete, "/api/v1/rule/"+testRuleId, "", nil); code >= 300 {
+		t.Fatalf("delete returned %d", code)
+	}
+	ruleRequest(t, http.MethodGet, "/api/v1/rule/"+testRuleId+"/history", "", &entries)
+	last := entries[len(entries)-1]
+	if last.Action != ruleActionDelete {
+		t.Fatalf("last history entry is %s, want delete", last.Action)
+	}
+	if code := ruleRequest(t, http.MethodPost, "/api/v1/rule/"+testRuleId+"/restore?version="+last.Id, "", nil); code != http.StatusOK {
+		t.Fatalf("restoring the delete returned %d", code)
+	}
+	if code := ruleRequest(t, http.MethodGet, "/api/v1/rule/"+testRuleId, "", &rule); code != http.StatusOK || rule.Jira != "FEDS-148" {
+		t.Errorf("after restoring the delete got %d with %+v", code, rule)
+	}
+}
+
+func TestRestoreRuleWeaviateError(t *testing.T) {
+	fake := newRuleTest(t)
+
+	ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "`+testRuleId+`", "project": "FEDS", "jira": "FEDS-148", "description": "release notes"}`, nil)
+	var entries []RuleHistoryEntry
+	ruleRequest(t, http.MethodGet, "/api/v1/rule/"+testRuleId+"/history", "", &entries)
+
+	// The lookup before saving fails, that's an error response rather than the server exiting
+	fake.fail = true
+	if code := ruleRequest(t, http.MethodPost, "/api/v1/rule/"+testRuleId+"/restore?version="+entries[0].Id, "", nil); code != http.StatusInternalServerError {
+		t.Errorf("restore with Weaviate failing returned %d, want 500", code)
+	}
+}
+
+func TestRestoreRuleSnapshot(t *testing.T) {
+	fake := newRuleTest(t)
+
+	ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "`+testRuleId+`", "project": "FEDS", "task": "Release", "jira": "FEDS-148", "description": "release notes"}`, nil)
+
+	var snapshot RuleSnapshot
+	if code := ruleRequest(t, http.MethodPost, "/api/v1/rule/snapshots?name=good", "", &snapshot); code >= 300 || snapshot.Count != 1 {
+		t.Fatalf("snapshot returned %d with %d rules", code, snapshot.Count)
+	}
+
+	// Changed and added after the snapshot
+	ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "`+testRuleId+`", "project": "FEDS", "task": "Release", "jira": "BAD-1", "description": "overwritten"}`, nil)
+	ruleRequest(t, http.MethodPost, "/api/v1/rule", `{"id": "00000000-0000-0000-0000-00000000000b", "project": "BAD", "jira": "BAD-2", "description": "bad import"}`, nil)
+
+	var result RuleSnapshotRestore
+	if code := ruleRequest(t, http.MethodPost, "/api/v1/rule/snapshots/"+snapshot.Id+"/restore", "", &result); code != http.StatusOK {
+		t.Fatalf("snapshot restore returned %d", code)
+	}
+	if result.Saved != 1 || result.Deleted != 1 {
+		t.Errorf("snapshot restore saved %d and deleted %d, want 1 and 1", result.Saved, result.Deleted)
+	}
+
+	if len(fake.objects) != 1 || fake.objects[testRuleId]["jira"] != "FEDS-148" {
+		t.Errorf("rules after the restore are %v, want only the snapshot's", fake.objects)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// learnRuleFromActivity turns a categorization a person confirmed or corrected
+// into an example rule, so categorization gets better from everyday use and
+// not only from hand curated CSV uploads. Only runs with LEARN_RULES=true.
+//
+// The new rule is tied to the closest existing rule for the same
+// project/task/jira, without one there's nothing to learn from. Nothing is
+// saved if a rule is already closer than LEARN_RULES_DUPLICATE_DISTANCE, it
+// wouldn't add anything if it's for the same project/task/jira and would
+// fight with it if it isn't. With RULE_CONFLICT_REJECT=true a rule for
+// something else closer than RULE_CONFLICT_DISTANCE stops it too, same as an
+// upload.
+func learnRuleFromActivity(activity Activity) {
+	if !learnRules || activity.Jira == "" || strings.TrimSpace(activity.InputDescription) == "" {
+		return
+	}
+
+	log.Printf("rule learning - learning from activity '%s'", activity.ActivityId)
+
+	candidates, err := categorizer.FindCandidates(context.Background(), activity.InputDescription)
+	if err != nil {
+		log.Printf("\terror finding similar rules, not learning: %v", err)
+		return
+	}
+
+	rule := Rule{
+		Project:     activity.Project,
+		Task:        activity.Task,
+		Jira:        activity.Jira,
+		Description: activity.InputDescription,
+	}
+
+	var parent *CandidateRule
+	for i, candidate := range candidates {
+		existing := Rule{Project: candidate.Project, Task: candidate.Task, Jira: candidate.Jira}
+
+		if !sameRuleTarget(rule, existing) {
+			if candidate.Distance < learnRulesDuplicateDistance || (ruleConflictReject && candidate.Distance < ruleConflictDistance) {
+				log.Printf("\trule '%s' for Jira '%s' is too close (distance %f), not learning", candidate.WeaviateId, candidate.Jira, candidate.Distance)
+				return
+			}
+			continue
+		}
+
+		if candidate.Distance < learnRulesDuplicateDistance {
+			log.Printf("\trule '%s' is already a near duplicate (distance %f), not learning", candidate.WeaviateId, candidate.Distance)
+			return
+		}
+		if parent == nil || candidate.Distance < parent.Distance {
+			parent = &candidates[i]
+		}
+	}
+
+	if parent == nil {
+		log.Printf("\tno rule for Jira '%s' to learn from, not learning", activity.Jira)
+		return
+	}
+
+	// Learned rules hang off the original rule rather than each other
+	rule.ParentId = parent.WeaviateId
+	if parent.ParentId != "" {
+		rule.ParentId = parent.ParentId
+	}
+
+	if _, err := saveRulesToWeaviate([]Rule{rule}); err != nil {
+		log.Printf("\terror saving learned rule: %v", err)
+		return
+	}
+
+	log.Printf("\tlearned rule for Jira '%s' with parent '%s'", rule.Jira, rule.ParentId)
+}
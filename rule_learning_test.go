@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLearnRuleFromActivity(t *testing.T) {
+	parent := CandidateRule{WeaviateId: "r1", Project: "FEDS", Task: "Release", Jira: "FEDS-148", Description: "release notes", Distance: 0.3}
+	learnedParent := CandidateRule{WeaviateId: "r2", Project: "FEDS", Task: "Release", Jira: "FEDS-148", Description: "notes", Distance: 0.2, ParentId: "r1"}
+	duplicate := CandidateRule{WeaviateId: "r3", Project: "FEDS", Task: "Release", Jira: "FEDS-148", Description: "release notes", Distance: 0.05}
+	other := func(distance float64) CandidateRule {
+		return CandidateRule{WeaviateId: "r4", Project: "IZG", Task: "Docs", Jira: "IZG-7", Description: "notes", Distance: distance}
+	}
+
+	tests := []struct {
+		name       string
+		candidates []CandidateRule
+		reject     bool
+		wantParent string
+	}{
+		{"learned under the parent", []CandidateRule{parent}, false, "r1"},
+		{"learned rules share the original parent", []CandidateRule{learnedParent, parent}, false, "r1"},
+		{"no parent", []CandidateRule{other(0.3)}, false, ""},
+		{"duplicate after a further match", []CandidateRule{parent, duplicate}, false, ""},
+		{"other target closer than the duplicate distance", []CandidateRule{other(0.05), parent}, false, ""},
+		{"other target within the conflict distance", []CandidateRule{other(0.12), parent}, false, "r1"},
+		{"other target within the conflict distance rejected", []CandidateRule{other(0.12), parent}, true, ""},
+	}
+
+	defer func(learn bool, duplicateDistance float64, conflictDistance float64, reject bool) {
+		learnRules, learnRulesDuplicateDistance, ruleConflictDistance, ruleConflictReject = learn, duplicateDistance, conflictDistance, reject
+	}(learnRules, learnRulesDuplicateDistance, ruleConflictDistance, ruleConflictReject)
+	learnRules, learnRulesDuplicateDistance, ruleConflictDistance = true, 0.1, 0.15
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newRuleTest(t)
+			newActivityTest(t, test.candidates...)
+			ruleConflictReject = test.reject
+
+			activity := testActivity("a1", testDay(14, 9))
+			activity.InputDescription = "wrote the release notes"
+			learnRuleFromActivity(activity)
+
+			if test.wantParent == "" {
+				if len(fake.objects) != 0 {
+					t.Errorf("saved %v, want nothing learned", fake.objects)
+				}
+				return
+			}
+			if len(fake.objects) != 1 {
+				t.Fatalf("saved %d rules, want 1", len(fake.objects))
+			}
+			for _, properties := range fake.objects {
+				if properties["parentId"] != test.wantParent || properties["jira"] != "FEDS-148" {
+					t.Errorf("learned rule %v, want FEDS-148 under %s", properties, test.wantParent)
+				}
+			}
+		})
+	}
+}
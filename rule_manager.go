@@ -9,10 +9,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate/entities/models"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 )
 
@@ -24,12 +24,21 @@ type Rule struct {
 	Task        string `json:"task"`
 	Jira        string `json:"jira"`
 	Description string `json:"description"`
+	ParentId    string `json:"parent_id"` // set on rules learned from activities, the rule they were an example of
 }
 
 func (h *RuleManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/v1/rule/test":
+		h.testRule(w, r)
+	case r.Method == "POST" && r.URL.Path == "/api/v1/rule/snapshots":
+		h.createRuleSnapshot(w, r)
+	case r.Method == "POST" && ruleSnapshotRestore.MatchString(r.URL.Path):
+		h.restoreRuleSnapshot(w, r)
+	case r.Method == "POST" && ruleRestoreById.MatchString(r.URL.Path):
+		h.restoreRule(w, r)
 	case r.Method == "POST":
 		contentType := r.Header.Get("Content-Type")
 		if strings.HasPrefix(contentType, "text/csv") {
@@ -39,8 +48,22 @@ func (h *RuleManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			http.Error(w, "Content-Type must be application/json or text/csv", http.StatusUnsupportedMediaType)
 		}
+	case r.Method == "GET" && r.URL.Path == "/api/v1/rule/conflicts":
+		h.getRuleConflicts(w, r)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/rule/snapshots":
+		h.listRuleSnapshots(w)
+	case r.Method == "GET" && ruleSnapshotById.MatchString(r.URL.Path):
+		h.getRuleSnapshot(w, r)
+	case r.Method == "GET" && ruleHistoryById.MatchString(r.URL.Path):
+		h.getRuleHistory(w, r)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/rule/list":
+		h.listRules(w, r)
+	case r.Method == "GET" && ruleById.MatchString(r.URL.Path):
+		h.getRuleById(w, r)
 	case r.Method == "GET":
-		h.getRulesCsv(w)
+		h.getRulesCsv(w, r)
+	case r.Method == "DELETE" && ruleById.MatchString(r.URL.Path):
+		h.deleteRule(w, r)
 	default:
 		http.Error(w, "invalid request", http.StatusBadRequest)
 	}
@@ -66,6 +89,10 @@ func (h *RuleManager) saveRule(w http.ResponseWriter, r *http.Request) {
 
 	// Convert single rule to slice for batch processing
 	rules := []Rule{rule}
+	if !checkUploadConflicts(w, r, rules) {
+		return
+	}
+
 	success, err := saveRulesToWeaviate(rules)
 	if err != nil {
 		http.Error(w, "Error saving rule to Weaviate: "+err.Error(), http.StatusInternalServerError)
@@ -155,6 +182,10 @@ func (h *RuleManager) saveCsvRules(w http.ResponseWriter, r *http.Request) {
 			Jira:        record[3],
 			Description: record[4],
 		}
+		// Optional, only there when re-uploading a CSV downloaded from GET
+		if len(record) > 5 {
+			rule.ParentId = record[5]
+		}
 		rules = append(rules, rule)
 	}
 
@@ -163,6 +194,17 @@ func (h *RuleManager) saveCsvRules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkUploadConflicts(w, r, rules) {
+		return
+	}
+
+	// So a bad import can be undone by restoring this
+	snapshot, err := snapshotRules(r.Context(), "before CSV import")
+	if err != nil {
+		http.Error(w, "Error saving rule snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Save rules to Weaviate
 	log.Printf("Processing %d rules from CSV", len(rules))
 	success, err := saveRulesToWeaviate(rules)
@@ -179,8 +221,9 @@ func (h *RuleManager) saveCsvRules(w http.ResponseWriter, r *http.Request) {
 	// Return success response
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": fmt.Sprintf("Successfully processed %d rules", len(rules)),
-		"count":   len(rules),
+		"message":     fmt.Sprintf("Successfully processed %d rules", len(rules)),
+		"count":       len(rules),
+		"snapshot_id": snapshot.Id,
 	})
 }
 
@@ -192,14 +235,15 @@ func saveRulesToWeaviate(rules []Rule) (bool, error) {
 	}
 
 	// Loop rules
-	for _, rule := range rules {
+	for i, rule := range rules {
 		// Check to see if Rule has id, assign if not
 		if rule.Id == "" {
 			rule.Id = uuid.New().String()
+			rules[i].Id = rule.Id
 		}
 
 		// See if Rule with this id exists in Weaviate
-		_, err := client.Data().ObjectsGetter().
+		existing, err := client.Data().ObjectsGetter().
 			WithClassName(weaviateClass).
 			WithID(rule.Id).
 			Do(context.Background())
@@ -210,10 +254,15 @@ func saveRulesToWeaviate(rules []Rule) (bool, error) {
 			if errors.As(err, &wce) && wce.StatusCode == 404 {
 				ruleExists = false
 			} else {
-				fmt.Printf("error getting existing collection: %v\n", err)
-				os.Exit(1)
+				return false, fmt.Errorf("error getting existing rule '%s': %w", rule.Id, err)
 			}
+		}
 
+		// Kept for the rule history
+		var before *Rule
+		if ruleExists && len(existing) > 0 {
+			previous := ruleFromObject(existing[0])
+			before = &previous
 		}
 
 		if ruleExists == false {
@@ -225,12 +274,15 @@ func saveRulesToWeaviate(rules []Rule) (bool, error) {
 					"task":        rule.Task,
 					"jira":        rule.Jira,
 					"description": rule.Description,
+					"parentId":    rule.ParentId,
 				}).
 				Do(context.Background())
 
 			if err != nil {
 				return false, err
 			}
+
+			ruleHistory.record(ruleActionCreate, nil, &rule)
 		} else {
 			err := client.Data().Updater().
 				WithMerge().
@@ -241,27 +293,91 @@ func saveRulesToWeaviate(rules []Rule) (bool, error) {
 					"task":        rule.Task,
 					"jira":        rule.Jira,
 					"description": rule.Description,
+					"parentId":    rule.ParentId,
 				}).
 				Do(context.Background())
 
 			if err != nil {
 				return false, err
 			}
+
+			if before == nil || *before != rule {
+				ruleHistory.record(ruleActionUpdate, before, &rule)
+			}
+		}
+	}
+
+	events.publish(eventRuleChanged, rules)
+
+	// Keep the local categorizer's copy up to date
+	if localRules != nil {
+		if err := localRules.Upsert(rules); err != nil {
+			log.Printf("error updating local rules: %v", err)
 		}
 	}
 
 	return true, nil
 }
 
-func (h *RuleManager) getRulesCsv(w http.ResponseWriter) {
+// Weaviate only hands back a page of objects at a time
+const weaviateRulePageSize = 100
+
+// fetchAllRules reads every rule out of Weaviate
+func fetchAllRules(ctx context.Context) ([]Rule, error) {
+	objects, err := fetchRuleObjects(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(objects))
+	for _, object := range objects {
+		rules = append(rules, ruleFromObject(object))
+	}
+	return rules, nil
+}
+
+// fetchRuleObjects reads every rule object, following the cursor page by page,
+// with their vectors if withVector is set
+func fetchRuleObjects(ctx context.Context, withVector bool) ([]*models.Object, error) {
 	client, err := weaviate.NewClient(weaviateConfig)
 	if err != nil {
-		http.Error(w, "error with Weaviate: "+err.Error(), http.StatusInternalServerError)
+		return nil, err
 	}
 
+	var rules []*models.Object
+	after := ""
+	for {
+		getter := client.Data().ObjectsGetter().
+			WithClassName(weaviateClass).
+			WithLimit(weaviateRulePageSize)
+		if after != "" {
+			getter = getter.WithAfter(after)
+		}
+		if withVector {
+			getter = getter.WithVector()
+		}
+
+		objects, err := getter.Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, objects...)
+
+		if len(objects) < weaviateRulePageSize {
+			return rules, nil
+		}
+		after = objects[len(objects)-1].ID.String()
+	}
+}
+
+func (h *RuleManager) getRulesCsv(w http.ResponseWriter, r *http.Request) {
 	filename := fmt.Sprintf("%s-rules.csv", weaviateClass)
 
-	rules, err := client.Data().ObjectsGetter().WithClassName(weaviateClass).Do(context.Background())
+	rules, err := fetchAllRules(r.Context())
+	if err != nil {
+		http.Error(w, "error with Weaviate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	log.Printf("got %d rules", len(rules))
 
@@ -277,26 +393,12 @@ func (h *RuleManager) getRulesCsv(w http.ResponseWriter) {
 	csvWriter := csv.NewWriter(w)
 	defer csvWriter.Flush()
 
-	// Loop rules returned by Weaviate, convert to Rule struct and write to CSV
-	headerWritten := false
-	for _, weaviateObject := range rules {
-		weaviateRule := weaviateObject.Properties.(map[string]interface{})
-		rule := Rule{
-			Id:          weaviateObject.ID.String(),
-			Project:     weaviateRule["project"].(string),
-			Task:        weaviateRule["task"].(string),
-			Jira:        weaviateRule["jira"].(string),
-			Description: weaviateRule["description"].(string),
-		}
-
-		if !headerWritten {
-			if err := csvWriter.Write(getRuleHeaders(rule)); err != nil {
-				http.Error(w, "error writing rules CSV header: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			headerWritten = true
-		}
+	if err := csvWriter.Write(getRuleHeaders(rules[0])); err != nil {
+		http.Error(w, "error writing rules CSV header: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	for _, rule := range rules {
 		if err := csvWriter.Write(getRuleSlice(rule)); err != nil {
 			http.Error(w, "error writing rules CSV row: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -304,3 +406,9 @@ func (h *RuleManager) getRulesCsv(w http.ResponseWriter) {
 	}
 
 }
+
+// Read a text property from a Weaviate object that may not have it set
+func stringProperty(properties map[string]interface{}, name string) string {
+	value, _ := properties[name].(string)
+	return value
+}
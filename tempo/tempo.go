@@ -0,0 +1,238 @@
+// Package tempo is a small client for the Tempo worklog REST API
+// (https://apidocs.tempo.io/). Only the pieces the activity tracker needs
+// are here.
+//
+// Everything goes through BaseURL and HTTPClient so it can be pointed at a
+// local stand-in server (httptest.NewServer) instead of api.tempo.io.
+package tempo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is Tempo Cloud's v4 API
+const DefaultBaseURL = "https://api.tempo.io/4"
+
+type Client struct {
+	// e.g. https://api.tempo.io/4, no trailing slash needed
+	BaseURL string
+	// Tempo API token, sent as a bearer token
+	Token      string
+	HTTPClient *http.Client
+}
+
+// WorkAttribute is a Tempo work attribute value, e.g. {"key": "_Account_", "value": "ACC1"}
+type WorkAttribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Worklog is what gets sent to create (or update) a worklog.
+//
+// Tempo Cloud v4 identifies the issue with IssueId, older Tempo APIs and
+// Tempo for Jira Server/Data Center use IssueKey. Set whichever your
+// instance expects, empty ones are left out.
+type Worklog struct {
+	IssueKey         string          `json:"issueKey,omitempty"`
+	IssueId          int             `json:"issueId,omitempty"`
+	AuthorAccountId  string          `json:"authorAccountId"`
+	TimeSpentSeconds int             `json:"timeSpentSeconds"`
+	StartDate        string          `json:"startDate"`           // YYYY-MM-DD
+	StartTime        string          `json:"startTime,omitempty"` // HH:MM:SS
+	Description      string          `json:"description"`
+	Attributes       []WorkAttribute `json:"attributes,omitempty"`
+}
+
+// WorklogResponse is the worklog as Tempo saved it
+type WorklogResponse struct {
+	TempoWorklogId   int    `json:"tempoWorklogId"`
+	TimeSpentSeconds int    `json:"timeSpentSeconds"`
+	StartDate        string `json:"startDate"`
+	StartTime        string `json:"startTime"`
+	Description      string `json:"description"`
+	Issue            struct {
+		Id  int    `json:"id"`
+		Key string `json:"key"`
+	} `json:"issue"`
+	Author struct {
+		AccountId string `json:"accountId"`
+	} `json:"author"`
+}
+
+// APIError is returned when Tempo answers with anything other than a 2xx
+type APIError struct {
+	StatusCode int
+	Status     string
+	// Messages from Tempo's {"errors": [{"message": ...}]} body, if it had one
+	Messages []string
+	Body     string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Messages) > 0 {
+		return fmt.Sprintf("tempo returned %s: %s", e.Status, strings.Join(e.Messages, "; "))
+	}
+	return fmt.Sprintf("tempo returned %s: %s", e.Status, e.Body)
+}
+
+func NewClient(baseURL string, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// FormatDate is the date format Tempo uses for startDate, from and to
+func FormatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// FormatTime is the time format Tempo uses for startTime
+func FormatTime(t time.Time) string {
+	return t.Format("15:04:05")
+}
+
+// CreateWorklog posts a new worklog and returns what Tempo saved, including its id
+func (c *Client) CreateWorklog(ctx context.Context, worklog Worklog) (WorklogResponse, error) {
+	var response WorklogResponse
+	err := c.do(ctx, http.MethodPost, "/worklogs", worklog, &response)
+	return response, err
+}
+
+// UpdateWorklog replaces an existing worklog with this one
+func (c *Client) UpdateWorklog(ctx context.Context, worklogId int, worklog Worklog) (WorklogResponse, error) {
+	var response WorklogResponse
+	err := c.do(ctx, http.MethodPut, fmt.Sprintf("/worklogs/%d", worklogId), worklog, &response)
+	return response, err
+}
+
+// DeleteWorklog removes a worklog, one that's already gone isn't an error
+func (c *Client) DeleteWorklog(ctx context.Context, worklogId int) error {
+	err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/worklogs/%d", worklogId), nil, nil)
+
+	var apiError *APIError
+	if errors.As(err, &apiError) && apiError.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// ListWorklogs returns the worklogs between from and to (inclusive dates),
+// only authorAccountId's if it's set. Follows Tempo's paging until the end.
+func (c *Client) ListWorklogs(ctx context.Context, from time.Time, to time.Time, authorAccountId string) ([]WorklogResponse, error) {
+	query := url.Values{}
+	query.Set("from", FormatDate(from))
+	query.Set("to", FormatDate(to))
+	query.Set("limit", "1000")
+
+	path := "/worklogs?" + query.Encode()
+	if authorAccountId != "" {
+		path = "/worklogs/user/" + url.PathEscape(authorAccountId) + "?" + query.Encode()
+	}
+
+	var worklogs []WorklogResponse
+	for path != "" {
+		var page struct {
+			Metadata struct {
+				Next string `json:"next"`
+			} `json:"metadata"`
+			Results []WorklogResponse `json:"results"`
+		}
+
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		worklogs = append(worklogs, page.Results...)
+
+		// next is a full URL, only the part after the base is wanted
+		path = strings.TrimPrefix(page.Metadata.Next, c.BaseURL)
+		if path != "" && !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("unexpected next page '%s' from Tempo", page.Metadata.Next)
+		}
+	}
+
+	return worklogs, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var requestBody io.Reader
+	if body != nil {
+		requestData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshalling request: %w", err)
+		}
+		requestBody = bytes.NewReader(requestData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, requestBody)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request to Tempo: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading Tempo response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp, responseBody)
+	}
+
+	if result == nil || len(responseBody) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(responseBody, result); err != nil {
+		return fmt.Errorf("error processing Tempo response: %w", err)
+	}
+
+	return nil
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiError := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+
+	var errorBody struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &errorBody) == nil {
+		for _, e := range errorBody.Errors {
+			apiError.Messages = append(apiError.Messages, e.Message)
+		}
+	}
+
+	return apiError
+}
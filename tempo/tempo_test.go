@@ -0,0 +1,163 @@
+package tempo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return NewClient(server.URL+"/", "token")
+}
+
+func TestCreateWorklog(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/worklogs" {
+			t.Errorf("got %s %s, want POST /worklogs", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want the bearer token", got)
+		}
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["issueId"] != 10042.0 || body["startTime"] != "09:30:00" {
+			t.Errorf("unexpected body %v", body)
+		}
+		if _, found := body["issueKey"]; found {
+			t.Errorf("empty issueKey was sent: %v", body)
+		}
+
+		w.Write([]byte(`{"tempoWorklogId": 7, "timeSpentSeconds": 3600, "startDate": "2025-05-14", "issue": {"id": 10042}}`))
+	})
+
+	saved, err := client.CreateWorklog(context.Background(), Worklog{
+		IssueId:          10042,
+		AuthorAccountId:  "author-1",
+		TimeSpentSeconds: 3600,
+		StartDate:        "2025-05-14",
+		StartTime:        "09:30:00",
+		Description:      "release notes",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.TempoWorklogId != 7 || saved.Issue.Id != 10042 {
+		t.Errorf("CreateWorklog() = %+v, want worklog 7 on issue 10042", saved)
+	}
+}
+
+func TestUpdateWorklog(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/worklogs/7" {
+			t.Errorf("got %s %s, want PUT /worklogs/7", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"tempoWorklogId": 7, "description": "edited"}`))
+	})
+
+	saved, err := client.UpdateWorklog(context.Background(), 7, Worklog{Description: "edited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Description != "edited" {
+		t.Errorf("UpdateWorklog() = %+v, want the edited description", saved)
+	}
+}
+
+func TestDeleteWorklog(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantError bool
+	}{
+		{http.StatusNoContent, false},
+		{http.StatusNotFound, false},
+		{http.StatusForbidden, true},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete || r.URL.Path != "/worklogs/7" {
+					t.Errorf("got %s %s, want DELETE /worklogs/7", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(test.status)
+			})
+
+			if err := client.DeleteWorklog(context.Background(), 7); (err != nil) != test.wantError {
+				t.Errorf("DeleteWorklog() error = %v, want error %t", err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestListWorklogsFollowsPages(t *testing.T) {
+	var serverURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/worklogs/user/author-1" {
+			t.Errorf("got %s, want the author's worklogs", r.URL.Path)
+		}
+		if r.URL.Query().Get("from") != "2025-05-14" || r.URL.Query().Get("to") != "2025-05-15" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprintf(w, `{"metadata": {"next": "%s/worklogs/user/author-1?from=2025-05-14&to=2025-05-15&offset=1"}, "results": [{"tempoWorklogId": 1}]}`, serverURL)
+			return
+		}
+		w.Write([]byte(`{"metadata": {}, "results": [{"tempoWorklogId": 2}]}`))
+	}))
+	defer server.Close()
+	serverURL = server.URL
+
+	client := NewClient(server.URL, "token")
+	from := time.Date(2025, 5, 14, 0, 0, 0, 0, time.UTC)
+
+	worklogs, err := client.ListWorklogs(context.Background(), from, from.AddDate(0, 0, 1), "author-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(worklogs) != 2 || worklogs[0].TempoWorklogId != 1 || worklogs[1].TempoWorklogId != 2 {
+		t.Errorf("ListWorklogs() = %+v, want worklogs 1 and 2", worklogs)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantMessages int
+		wantError    string
+	}{
+		{"tempo errors", `{"errors": [{"message": "Issue not found"}, {"message": "Bad date"}]}`, 2, "tempo returned 400 Bad Request: Issue not found; Bad date"},
+		{"plain body", `bad request`, 0, "tempo returned 400 Bad Request: bad request"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(test.body))
+			})
+
+			_, err := client.CreateWorklog(context.Background(), Worklog{})
+
+			var apiError *APIError
+			if !errors.As(err, &apiError) {
+				t.Fatalf("CreateWorklog() error = %v, want an APIError", err)
+			}
+			if apiError.StatusCode != http.StatusBadRequest || len(apiError.Messages) != test.wantMessages || err.Error() != test.wantError {
+				t.Errorf("got status %d, %d messages, %q", apiError.StatusCode, len(apiError.Messages), err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/austinmoody/aidea-activity-tracking/tempo"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where an activity is on its way to Tempo, empty until it's pushed
+const (
+	tempoStatePending = "pending"
+	tempoStateSent    = "sent"
+	tempoStateFailed  = "failed"
+)
+
+// Retries back off from the worker interval up to this
+const maxTempoRetryDelay = time.Hour
+
+// TempoOutboxEntry is a push to Tempo that hasn't finished yet, one JSON file
+// per activity in JIRA_TEMPO_OUTBOX_DIR.
+//
+// An entry is "sent" once Tempo has the worklog but the activity hasn't been
+// updated yet. The worklog id is saved first so a retry only updates the
+// activity and never posts the worklog a second time. A post that failed
+// without an answer (a timeout say) may still have been saved by Tempo, so
+// before posting again the retry looks for the worklog it sent last time.
+type TempoOutboxEntry struct {
+	Key            string         `json:"key"` // one entry per activity, from the activity id
+	ActivityId     string         `json:"activity_id"`
+	State          string         `json:"state"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at"`
+	LastError      string         `json:"last_error,omitempty"`
+	TempoWorklogId int            `json:"tempo_worklog_id,omitempty"`
+	Worklog        *tempo.Worklog `json:"worklog,omitempty"` // last one sent
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+type tempoOutbox struct {
+	mu  sync.Mutex
+	dir string
+	// Keys being worked on right now, so the worker and a request don't both post one
+	inflight map[string]bool
+}
+
+var tempoQueue *tempoOutbox
+
+func newTempoOutbox(dir string) (*tempoOutbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating outbox directory '%s': %w", dir, err)
+	}
+
+	outbox := &tempoOutbox{dir: dir, inflight: make(map[string]bool)}
+
+	entries, err := outbox.list()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("tempo outbox - %d entries in '%s'", len(entries), dir)
+
+	return outbox, nil
+}
+
+// One outbox entry per activity no matter how many times it's pushed
+func tempoIdempotencyKey(activityId string) string {
+	return "activity-" + activityId
+}
+
+// enqueue adds a push for the activity. If one is already pending it's left
+// alone, a failed one is reset to try again.
+func (o *tempoOutbox) enqueue(activity Activity) (TempoOutboxEntry, error) {
+	key := tempoIdempotencyKey(activity.ActivityId)
+
+	o.mu.Lock()
+	entry, err := o.read(key)
+	switch {
+	case err == nil && entry.State != tempoStateFailed:
+		o.mu.Unlock()
+		return entry, nil
+	case err == nil:
+		entry.State = tempoStatePending
+		entry.Attempts = 0
+		entry.LastError = ""
+	case errors.Is(err, os.ErrNotExist):
+		entry = TempoOutboxEntry{
+			Key:        key,
+			ActivityId: activity.ActivityId,
+			State:      tempoStatePending,
+			CreatedAt:  time.Now(),
+		}
+	default:
+		o.mu.Unlock()
+		return entry, err
+	}
+
+	entry.NextAttemptAt = time.Now()
+	err = o.write(entry)
+	o.mu.Unlock()
+	if err != nil {
+		return entry, err
+	}
+
+	log.Printf("tempo outbox - activity '%s' queued", activity.ActivityId)
+
+	if _, err := setTempoFields(activity.ActivityId, func(a *Activity) { a.TempoState = tempoStatePending }); err != nil {
+		log.Printf("\terror marking activity '%s' pending: %v", activity.ActivityId, err)
+	}
+
+	return entry, nil
+}
+
+// attempt tries an entry now whether or not it's due. Once the activity has
+// been updated the entry is removed and comes back with the sent state.
+func (o *tempoOutbox) attempt(ctx context.Context, key string) (TempoOutboxEntry, error) {
+	o.mu.Lock()
+	entry, err := o.read(key)
+	if err != nil {
+		o.mu.Unlock()
+		return entry, err
+	}
+	if o.inflight[key] {
+		o.mu.Unlock()
+		return entry, nil
+	}
+	o.inflight[key] = true
+	o.mu.Unlock()
+
+	defer func() {
+		o.mu.Lock()
+		delete(o.inflight, key)
+		o.mu.Unlock()
+	}()
+
+	activity, err := activityStore.Get(entry.ActivityId)
+	if errors.Is(err, errActivityNotFound) {
+		log.Printf("tempo outbox - activity '%s' is gone, dropping it", entry.ActivityId)
+		return entry, o.remove(key)
+	}
+	if err != nil {
+		return o.retryLater(entry, err)
+	}
+
+	if entry.TempoWorklogId == 0 && activity.PostedToJiraTempo {
+		// Already made it to Tempo some other way
+		entry.TempoWorklogId = activity.TempoWorklogId
+		entry.State = tempoStateSent
+	}
+
+	if entry.TempoWorklogId == 0 && entry.Attempts > 0 && entry.Worklog != nil {
+		// The last attempt may have reached Tempo even though it failed here
+		posted, err := findPostedWorklog(ctx, *entry.Worklog)
+		if err != nil {
+			return o.retryLater(entry, err)
+		}
+		if posted != 0 {
+			log.Printf("tempo outbox - activity '%s' was already posted as worklog %d", activity.ActivityId, posted)
+			entry.TempoWorklogId = posted
+			entry.State = tempoStateSent
+			entry.LastError = ""
+			if err := o.save(entry); err != nil {
+				log.Printf("tempo outbox - error saving sent entry for activity '%s': %v", activity.ActivityId, err)
+			}
+		}
+	}
+
+	if entry.TempoWorklogId == 0 {
+		entry.Attempts++
+
+		// Built from the activity as it is now, edits made while queued are included
+		worklog, err := newTempoWorklog(ctx, activity)
+		if err != nil {
+			if temporaryJiraError(err) && entry.Attempts < tempoOutboxMaxAttempts {
+				return o.retryLater(entry, err)
+			}
+			return o.giveUp(entry, err)
+		}
+		entry.Worklog = &worklog
+
+		saved, err := tempoClient.CreateWorklog(ctx, worklog)
+		if err != nil {
+			log.Printf("tempo outbox - error posting activity '%s', attempt %d: %v", activity.ActivityId, entry.Attempts, err)
+			if permanentTempoError(err) || entry.Attempts >= tempoOutboxMaxAttempts {
+				return o.giveUp(entry, err)
+			}
+			return o.retryLater(entry, err)
+		}
+
+		entry.TempoWorklogId = saved.TempoWorklogId
+		entry.State = tempoStateSent
+		entry.LastError = ""
+		if err := o.save(entry); err != nil {
+			log.Printf("tempo outbox - error saving sent entry for activity '%s': %v", activity.ActivityId, err)
+		}
+
+		log.Printf("tempo outbox - activity '%s' posted to Tempo as worklog %d", activity.ActivityId, saved.TempoWorklogId)
+	}
+
+	activity, err = setTempoFields(entry.ActivityId, func(a *Activity) {
+		a.PostedToJiraTempo = true
+		a.TempoWorklogId = entry.TempoWorklogId
+		a.TempoState = tempoStateSent
+	})
+	if err != nil {
+		// The worklog id is on the entry so the retry only has to do this part
+		return o.retryLater(entry, err)
+	}
+
+	// Edited while the post was going, the edit didn't know there was a worklog to change
+	if worklog, err := newTempoWorklog(ctx, activity); err == nil && entry.Worklog != nil && !reflect.DeepEqual(worklog, *entry.Worklog) {
+		if _, err := tempoClient.UpdateWorklog(ctx, entry.TempoWorklogId, worklog); err != nil {
+			log.Printf("tempo outbox - error updating worklog %d for activity '%s': %v", entry.TempoWorklogId, activity.ActivityId, err)
+			return o.retryLater(entry, err)
+		}
+		entry.Worklog = &worklog
+		log.Printf("tempo outbox - worklog %d updated with edits to activity '%s'", entry.TempoWorklogId, activity.ActivityId)
+	}
+
+	publishActivity(eventActivityPosted, activity)
+
+	return entry, o.remove(key)
+}
+
+func (o *tempoOutbox) retryLater(entry TempoOutboxEntry, err error) (TempoOutboxEntry, error) {
+	entry.LastError = err.Error()
+	entry.NextAttemptAt = time.Now().Add(tempoRetryDelay(entry.Attempts))
+	return entry, o.save(entry)
+}
+
+func (o *tempoOutbox) giveUp(entry TempoOutboxEntry, err error) (TempoOutboxEntry, error) {
+	log.Printf("tempo outbox - giving up on activity '%s': %v", entry.ActivityId, err)
+
+	entry.State = tempoStateFailed
+	entry.LastError = err.Error()
+
+	activity, err := setTempoFields(entry.ActivityId, func(a *Activity) { a.TempoState = tempoStateFailed })
+	if err != nil {
+		log.Printf("\terror marking activity '%s' failed: %v", entry.ActivityId, err)
+	} else {
+		publishActivity(eventActivityUpdated, activity)
+	}
+
+	return entry, o.save(entry)
+}
+
+// setTempoFields reads the activity again and changes only what set touches,
+// anything edited while Tempo was being called is kept
+func setTempoFields(activityId string, set func(*Activity)) (Activity, error) {
+	activity, err := activityStore.Get(activityId)
+	if err != nil {
+		return activity, err
+	}
+
+	set(&activity)
+	return activity, activityStore.Update(activity)
+}
+
+// findPostedWorklog looks through the author's worklogs on the worklog's day
+// for one matching it, returning its id or 0 if Tempo doesn't have it
+func findPostedWorklog(ctx context.Context, worklog tempo.Worklog) (int, error) {
+	date, err := time.Parse("2006-01-02", worklog.StartDate)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing worklog start date '%s': %w", worklog.StartDate, err)
+	}
+
+	worklogs, err := tempoClient.ListWorklogs(ctx, date, date, worklog.AuthorAccountId)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, existing := range worklogs {
+		if sameTempoWorklog(worklog, existing) {
+			return existing.TempoWorklogId, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// A 4xx from Tempo (other than rate limiting) won't get better by retrying
+func permanentTempoError(err error) bool {
+	var apiError *tempo.APIError
+	if !errors.As(err, &apiError) {
+		return false
+	}
+	return apiError.StatusCode >= 400 && apiError.StatusCode < 500 && apiError.StatusCode != http.StatusTooManyRequests
+}
+
+// Double the wait after each attempt, starting at the worker interval
+func tempoRetryDelay(attempts int) time.Duration {
+	delay := tempoOutboxInterval
+	for i := 1; i < attempts && delay < maxTempoRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxTempoRetryDelay)
+}
+
+// run retries whatever is due every interval until the process exits
+func (o *tempoOutbox) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		entries, err := o.list()
+		if err != nil {
+			log.Printf("tempo outbox - error reading outbox: %v", err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.State == tempoStateFailed || entry.NextAttemptAt.After(time.Now()) {
+				continue
+			}
+			if _, err := o.attempt(context.Background(), entry.Key); err != nil {
+				log.Printf("tempo outbox - error with activity '%s': %v", entry.ActivityId, err)
+			}
+		}
+	}
+}
+
+// list returns every entry, oldest first
+func (o *tempoOutbox) list() ([]TempoOutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TempoOutboxEntry, 0, len(files))
+	for _, file := range files {
+		entry, err := o.read(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			log.Printf("tempo outbox - skipping '%s': %v", file, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b TempoOutboxEntry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return entries, nil
+}
+
+func (o *tempoOutbox) save(entry TempoOutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.write(entry)
+}
+
+func (o *tempoOutbox) remove(key string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := os.Remove(o.filename(key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// read and write expect o.mu to be held
+
+func (o *tempoOutbox) read(key string) (TempoOutboxEntry, error) {
+	var entry TempoOutboxEntry
+
+	data, err := os.ReadFile(o.filename(key))
+	if err != nil {
+		return entry, err
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("error parsing outbox entry '%s': %w", key, err)
+	}
+
+	return entry, nil
+}
+
+// Written to a temp file and renamed so a crash never leaves half an entry
+func (o *tempoOutbox) write(entry TempoOutboxEntry) error {
+	entry.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := o.filename(entry.Key) + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, o.filename(entry.Key))
+}
+
+func (o *tempoOutbox) filename(key string) string {
+	return filepath.Join(o.dir, key+".json")
+}
+
+func (h *ActivityManager) listTempoOutbox(w http.ResponseWriter) {
+
+	log.Println("activity manager - tempo outbox request received")
+
+	entries, err := tempoQueue.list()
+	if err != nil {
+		http.Error(w, "Error reading Tempo outbox: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
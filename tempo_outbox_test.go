@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/austinmoody/aidea-activity-tracking/tempo"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPermanentTempoError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad request", &tempo.APIError{StatusCode: http.StatusBadRequest}, true},
+		{"not found", &tempo.APIError{StatusCode: http.StatusNotFound}, true},
+		{"wrapped forbidden", fmt.Errorf("posting: %w", &tempo.APIError{StatusCode: http.StatusForbidden}), true},
+		{"rate limited", &tempo.APIError{StatusCode: http.StatusTooManyRequests}, false},
+		{"server error", &tempo.APIError{StatusCode: http.StatusBadGateway}, false},
+		{"network error", errors.New("connection refused"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := permanentTempoError(test.err); got != test.want {
+				t.Errorf("permanentTempoError(%v) = %t, want %t", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTempoRetryDelay(t *testing.T) {
+	defer func(interval time.Duration) { tempoOutboxInterval = interval }(tempoOutboxInterval)
+	tempoOutboxInterval = 30 * time.Second
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := tempoRetryDelay(test.attempts); got != test.want {
+			t.Errorf("tempoRetryDelay(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+// fakeTempo keeps worklogs in memory. failPosts makes that many posts save
+// the worklog and then answer with a 503, like a request timing out after
+// Tempo got it.
+type fakeTempo struct {
+	mu        sync.Mutex
+	worklogs  map[int]tempo.Worklog
+	nextId    int
+	posts     int
+	updates   int
+	failPosts int
+	onPost    func()
+}
+
+func (f *fakeTempo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/worklogs":
+		var worklog tempo.Worklog
+		json.NewDecoder(r.Body).Decode(&worklog)
+		f.posts++
+		f.nextId++
+		f.worklogs[f.nextId] = worklog
+		if f.onPost != nil {
+			f.onPost()
+		}
+		if f.failPosts > 0 {
+			f.failPosts--
+			http.Error(w, "timed out", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(f.response(f.nextId, worklog))
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/worklogs/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/worklogs/"))
+		var worklog tempo.Worklog
+		json.NewDecoder(r.Body).Decode(&worklog)
+		f.updates++
+		f.worklogs[id] = worklog
+		json.NewEncoder(w).Encode(f.response(id, worklog))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/worklogs/user/"):
+		results := []tempo.WorklogResponse{}
+		for id, worklog := range f.worklogs {
+			if worklog.StartDate >= r.URL.Query().Get("from") && worklog.StartDate <= r.URL.Query().Get("to") {
+				results = append(results, f.response(id, worklog))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"metadata": map[string]string{}, "results": results})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeTempo) response(id int, worklog tempo.Worklog) tempo.WorklogResponse {
+	response := tempo.WorklogResponse{
+		TempoWorklogId:   id,
+		TimeSpentSeconds: worklog.TimeSpentSeconds,
+		StartDate:        worklog.StartDate,
+		StartTime:        worklog.StartTime,
+		Description:      worklog.Description,
+	}
+	response.Issue.Id = worklog.IssueId
+	response.Issue.Key = worklog.IssueKey
+	response.Author.AccountId = worklog.AuthorAccountId
+	return response
+}
+
+// newOutboxTest points the globals the outbox uses at a fake Tempo and a
+// throwaway store holding one categorized activity
+func newOutboxTest(t *testing.T, fake *fakeTempo) (*tempoOutbox, Activity) {
+	t.Helper()
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := newSqliteActivityStore(filepath.Join(t.TempDir(), "activities.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousStore, previousClient, previousInterval := activityStore, tempoClient, tempoOutboxInterval
+	previousAuthor, previousMaxAttempts, previousIssueField := tempoAuthorAccountId, tempoOutboxMaxAttempts, tempoIssueField
+	t.Cleanup(func() {
+		activityStore, tempoClient, tempoOutboxInterval = previousStore, previousClient, previousInterval
+		tempoAuthorAccountId, tempoOutboxMaxAttempts, tempoIssueField = previousAuthor, previousMaxAttempts, previousIssueField
+	})
+	activityStore = store
+	tempoClient = tempo.NewClient(server.URL, "token")
+	tempoOutboxInterval = 0
+	tempoOutboxMaxAttempts = 8
+	tempoAuthorAccountId = "author-1"
+	tempoIssueField = tempoIssueByKey
+
+	outbox, err := newTempoOutbox(filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activity := Activity{
+		ActivityId:       "a1",
+		InputDescription: "1h on FEDS-148 release notes",
+		Project:          "FEDS",
+		Task:             "Release",
+		Jira:             "FEDS-148",
+		Categorized:      true,
+		Duration:         "1h",
+		TimeSpent:        time.Hour,
+		CreatedAt:        time.Date(2025, 5, 14, 9, 0, 0, 0, time.UTC),
+	}
+	if err := activityStore.Create(activity); err != nil {
+		t.Fatal(err)
+	}
+
+	return outbox, activity
+}
+
+func TestTempoOutboxRetryAdoptsPostedWorklog(t *testing.T) {
+	fake := &fakeTempo{worklogs: make(map[int]tempo.Worklog), failPosts: 1}
+	outbox, activity := newOutboxTest(t, fake)
+
+	entry, err := outbox.enqueue(activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err = outbox.attempt(context.Background(), entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.State != tempoStatePending || entry.Attempts != 1 {
+		t.Fatalf("after a failed post the entry is %s with %d attempts, want pending with 1", entry.State, entry.Attempts)
+	}
+
+	if _, err := outbox.attempt(context.Background(), entry.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.posts != 1 {
+		t.Errorf("Tempo got %d posts, want 1", fake.posts)
+	}
+
+	saved, err := activityStore.Get(activity.ActivityId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.PostedToJiraTempo || saved.TempoWorklogId != 1 || saved.TempoState != tempoStateSent {
+		t.Errorf("activity posted=%t worklog=%d state=%s, want posted to worklog 1 and sent", saved.PostedToJiraTempo, saved.TempoWorklogId, saved.TempoState)
+	}
+
+	entries, err := outbox.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("outbox has %d entries, want none", len(entries))
+	}
+}
+
+func TestTempoOutboxKeepsEditsMadeWhilePosting(t *testing.T) {
+	fake := &fakeTempo{worklogs: make(map[int]tempo.Worklog)}
+	outbox, activity := newOutboxTest(t, fake)
+
+	// Someone edits the activity while Tempo is still answering the post
+	fake.onPost = func() {
+		edited, _ := activityStore.Get(activity.ActivityId)
+		edited.Task = "Docs"
+		edited.InputDescription = "1h on FEDS-148 release notes and docs"
+		edited.ManuallyEdited = true
+		activityStore.Update(edited)
+	}
+
+	entry, err := outbox.enqueue(activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := outbox.attempt(context.Background(), entry.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := activityStore.Get(activity.ActivityId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Task != "Docs" || !saved.ManuallyEdited || saved.InputDescription != "1h on FEDS-148 release notes and docs" {
+		t.Errorf("edit was overwritten: %+v", saved)
+	}
+	if !saved.PostedToJiraTempo || saved.TempoState != tempoStateSent {
+		t.Errorf("activity posted=%t state=%s, want posted and sent", saved.PostedToJiraTempo, saved.TempoState)
+	}
+
+	if fake.updates != 1 || fake.worklogs[saved.TempoWorklogId].Description != saved.InputDescription {
+		t.Errorf("worklog has description %q after %d updates, want the edited one", fake.worklogs[saved.TempoWorklogId].Description, fake.updates)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/austinmoody/aidea-activity-tracking/tempo"
+	"strconv"
+	"strings"
+)
+
+var errNoTempoWorklogId = errors.New("activity was posted to Jira/Tempo before worklog ids were recorded and can't be changed")
+
+// newTempoWorklog builds the worklog Tempo gets for an activity. The work date
+// and time come from when the work happened (WorkDate), not when it was entered.
+//
+// Tempo Cloud wants the numeric Jira issue id while older Tempo APIs take the
+// issue key, JIRA_TEMPO_ISSUE_FIELD says which. A rule's Jira value that is
+// just a number is always sent as the id, a key is looked up in Jira for it.
+func newTempoWorklog(ctx context.Context, activity Activity) (tempo.Worklog, error) {
+	if activity.Jira == "" {
+		return tempo.Worklog{}, fmt.Errorf("activity has no Jira issue, categorize it first")
+	}
+
+	durationInSeconds, err := getDurationInSeconds(activity)
+	if err != nil {
+		return tempo.Worklog{}, err
+	}
+
+	worklog := tempo.Worklog{
+		AuthorAccountId:  tempoAuthorAccountId,
+		TimeSpentSeconds: durationInSeconds,
+		StartDate:        tempo.FormatDate(activity.WorkDate()),
+		StartTime:        tempo.FormatTime(activity.WorkDate()),
+		Description:      activity.InputDescription,
+		Attributes:       tempoWorkAttributes,
+	}
+
+	if issueId, err := strconv.Atoi(activity.Jira); err == nil {
+		worklog.IssueId = issueId
+	} else if tempoIssueField == tempoIssueByKey {
+		worklog.IssueKey = activity.Jira
+	} else {
+		worklog.IssueId, err = jiraIssues.issueId(ctx, activity.Jira)
+		if err != nil {
+			return tempo.Worklog{}, err
+		}
+	}
+
+	return worklog, nil
+}
+
+// Whether an edit touched anything that's in the worklog, the project and task aren't
+func tempoWorklogChanged(before Activity, after Activity) bool {
+	return before.Jira != after.Jira ||
+		before.InputDescription != after.InputDescription ||
+		before.TimeSpent != after.TimeSpent ||
+		before.Duration != after.Duration
+}
+
+// Whether a worklog read back from Tempo is the one that was sent
+func sameTempoWorklog(sent tempo.Worklog, existing tempo.WorklogResponse) bool {
+	sameIssue := (sent.IssueId != 0 && sent.IssueId == existing.Issue.Id) ||
+		(sent.IssueKey != "" && strings.EqualFold(sent.IssueKey, existing.Issue.Key))
+
+	return sameIssue &&
+		sent.StartDate == existing.StartDate &&
+		sent.StartTime == existing.StartTime &&
+		sent.TimeSpentSeconds == existing.TimeSpentSeconds &&
+		sent.Description == existing.Description
+}
+
+// Parse work attributes like "_Account_=ACC1,_Category_=Development"
+func parseTempoWorkAttributes(value string) ([]tempo.WorkAttribute, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var attributes []tempo.WorkAttribute
+	for _, part := range strings.Split(value, ",") {
+		key, attributeValue, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("work attribute '%s' should look like _Account_=ACC1", part)
+		}
+
+		attributes = append(attributes, tempo.WorkAttribute{
+			Key:   strings.TrimSpace(key),
+			Value: strings.TrimSpace(attributeValue),
+		})
+	}
+
+	return attributes, nil
+}
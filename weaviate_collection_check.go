@@ -6,20 +6,20 @@ import (
 	"fmt"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
 	"log"
-	"os"
 
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
 	"github.com/weaviate/weaviate/entities/models"
 )
 
-func collectionCheck() {
+// collectionCheck makes sure the rules collection exists with every property
+// the Rule struct needs
+func collectionCheck() error {
 
 	log.Printf("collection check - looking for '%s'\n", weaviateClass)
 
 	client, err := weaviate.NewClient(weaviateConfig)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
 	// Define the collection
@@ -54,6 +54,16 @@ func collectionCheck() {
 				Name:     "description",
 				DataType: []string{"text"},
 			},
+			{
+				Name:     "parentId",
+				DataType: []string{"text"},
+				// Just a reference to another rule, keep it out of the vector
+				ModuleConfig: map[string]interface{}{
+					"text2vec-ollama": map[string]interface{}{
+						"skip": true,
+					},
+				},
+			},
 		},
 	}
 
@@ -65,8 +75,7 @@ func collectionCheck() {
 		if errors.As(err, &wce) && wce.StatusCode == 404 {
 			weaviateClassExists = false
 		} else {
-			fmt.Printf("error getting existing collection: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("error getting existing collection: %w", err)
 		}
 	}
 
@@ -74,13 +83,49 @@ func collectionCheck() {
 		log.Printf("collection check - collection '%s' not found, will create", classObj.Class)
 		err = client.Schema().ClassCreator().WithClass(classObj).Do(context.Background())
 		if err != nil {
-			fmt.Printf("error adding collection: '%v'\n", err)
-			os.Exit(1)
+			return fmt.Errorf("error adding collection: %w", err)
 		}
 	} else {
 		log.Printf("collection check - collection '%s' already exists", classObj.Class)
+		if err := addMissingProperties(client, classObj); err != nil {
+			return err
+		}
 	}
 
 	// TODO - may want way to update collection if class name exists but parameters are different
 
+	return nil
+}
+
+// Collections created before a property was added to the Rule struct need it added
+func addMissingProperties(client *weaviate.Client, classObj *models.Class) error {
+	existing, err := client.Schema().ClassGetter().WithClassName(classObj.Class).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting existing collection: %w", err)
+	}
+
+	for _, property := range classObj.Properties {
+		found := false
+		for _, existingProperty := range existing.Properties {
+			if existingProperty.Name == property.Name {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		log.Printf("collection check - adding property '%s' to '%s'", property.Name, classObj.Class)
+		err = client.Schema().PropertyCreator().
+			WithClassName(classObj.Class).
+			WithProperty(property).
+			Do(context.Background())
+		if err != nil {
+			return fmt.Errorf("error adding property '%s': %w", property.Name, err)
+		}
+	}
+
+	return nil
 }